@@ -2,7 +2,10 @@ module github.com/a-inacio/edt-go
 
 go 1.19
 
-require github.com/a-inacio/rosetta-logger-go v0.0.0-alpha.2
+require (
+	github.com/a-inacio/rosetta-logger-go v0.0.0-alpha.2
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	go.uber.org/atomic v1.10.0 // indirect
@@ -0,0 +1,151 @@
+package mermaid
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokIdent tokenKind = iota
+	tokString
+	tokArrow
+	tokSeparator
+	tokLBrace
+	tokRBrace
+	tokStar
+	tokStereotype
+	tokClass
+	tokText
+)
+
+type token struct {
+	kind   tokenKind
+	value  string
+	line   int
+	column int
+}
+
+// SyntaxError is returned when the graph cannot be parsed, line and column are 1-based.
+type SyntaxError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+func syntaxError(line int, column int, format string, a ...any) *SyntaxError {
+	return &SyntaxError{Line: line, Column: column, Message: fmt.Sprintf(format, a...)}
+}
+
+// lexLine splits a line into tokens, comments (starting by %%) are dropped and anything after a colon is kept as a
+// single text token.
+func lexLine(line string, lineNo int) ([]token, error) {
+	runes := []rune(line)
+	var tokens []token
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		column := i + 1
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case hasPrefix(runes, i, "%%"):
+			return tokens, nil
+		case hasPrefix(runes, i, "-->"):
+			tokens = append(tokens, token{kind: tokArrow, value: "-->", line: lineNo, column: column})
+			i += 3
+		case hasPrefix(runes, i, "--"):
+			tokens = append(tokens, token{kind: tokSeparator, value: "--", line: lineNo, column: column})
+			i += 2
+		case hasPrefix(runes, i, "[*]"):
+			tokens = append(tokens, token{kind: tokStar, value: "[*]", line: lineNo, column: column})
+			i += 3
+		case hasPrefix(runes, i, "<<"):
+			end := strings.Index(string(runes[i+2:]), ">>")
+			if end < 0 {
+				return nil, syntaxError(lineNo, column, "stereotype not closed")
+			}
+
+			value := string(runes[i+2:])[:end]
+			tokens = append(tokens, token{kind: tokStereotype, value: value, line: lineNo, column: column})
+			i += 2 + len([]rune(value)) + 2
+		case hasPrefix(runes, i, ":::"):
+			start := i + 3
+			i = start
+			for i < len(runes) && isIdentRune(runes, i) {
+				i++
+			}
+
+			if i == start {
+				return nil, syntaxError(lineNo, column, "missing class name")
+			}
+
+			tokens = append(tokens, token{kind: tokClass, value: string(runes[start:i]), line: lineNo, column: column})
+		case r == ':':
+			text := strings.TrimSpace(string(runes[i+1:]))
+			tokens = append(tokens, token{kind: tokText, value: text, line: lineNo, column: column})
+			return tokens, nil
+		case r == '{':
+			tokens = append(tokens, token{kind: tokLBrace, value: "{", line: lineNo, column: column})
+			i++
+		case r == '}':
+			tokens = append(tokens, token{kind: tokRBrace, value: "}", line: lineNo, column: column})
+			i++
+		case r == '"':
+			end := strings.IndexRune(string(runes[i+1:]), '"')
+			if end < 0 {
+				return nil, syntaxError(lineNo, column, "string not closed")
+			}
+
+			value := string(runes[i+1:])[:end]
+			tokens = append(tokens, token{kind: tokString, value: value, line: lineNo, column: column})
+			i += 1 + len([]rune(value)) + 1
+		case isIdentRune(runes, i):
+			start := i
+			for i < len(runes) && isIdentRune(runes, i) {
+				i++
+			}
+
+			tokens = append(tokens, token{kind: tokIdent, value: string(runes[start:i]), line: lineNo, column: column})
+		default:
+			return nil, syntaxError(lineNo, column, "unexpected character %q", r)
+		}
+	}
+
+	return tokens, nil
+}
+
+// isIdentRune tells if the rune at the given position can be part of an identifier, dashes are accepted as long as
+// they are not the start of an arrow, e.g. `stateDiagram-v2`.
+func isIdentRune(runes []rune, i int) bool {
+	r := runes[i]
+
+	if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '.' {
+		return true
+	}
+
+	return r == '-' && i+1 < len(runes) && runes[i+1] != '-' && runes[i+1] != '>' && !unicode.IsSpace(runes[i+1])
+}
+
+func hasPrefix(runes []rune, i int, prefix string) bool {
+	p := []rune(prefix)
+
+	if i+len(p) > len(runes) {
+		return false
+	}
+
+	for j, r := range p {
+		if runes[i+j] != r {
+			return false
+		}
+	}
+
+	return true
+}
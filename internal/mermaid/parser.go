@@ -1,57 +1,429 @@
 package mermaid
 
 import (
-	"fmt"
 	"strings"
 )
 
 type Node struct {
 	From             string
 	To               string
+	Label            string
+	Parent           string
 	SourceIsInitial  bool
 	TargetIsTerminal bool
+	// Line is where the transition was declared.
+	Line int
 }
 
+// Note is a note attached to a state.
+type Note struct {
+	State    string
+	Position string
+	Text     string
+}
+
+// Graph is the result of parsing a state diagram.
+type Graph struct {
+	// Direction is the rendering direction, if declared (LR, RL, TB or BT).
+	Direction string
+	// Transitions holds every transition, in order of declaration.
+	Transitions []Node
+	// States holds every state name, in order of first appearance.
+	States []string
+	// Parents maps a state name to its enclosing composite state, top level states are not included.
+	Parents map[string]string
+	// Regions maps a state name to the concurrent region, separated by `--`, it belongs to inside its composite state.
+	Regions map[string]int
+	// Descriptions maps a state name to its description, from `state "Description" as X` or `X : Description`.
+	Descriptions map[string]string
+	// Kinds maps a state name to its stereotype, from `state X <<choice>>` (choice, fork or join).
+	// The history and deepHistory stereotypes, not part of Mermaid, declare history pseudo-states.
+	Kinds map[string]string
+	// Notes holds every note, in order of declaration.
+	Notes []Note
+}
+
+type scope struct {
+	name   string
+	region int
+}
+
+type parser struct {
+	lines  []string
+	lineNo int
+	g      *Graph
+	seen   map[string]bool
+	scopes []scope
+}
+
+// Parse parses a Mermaid state diagram, returning its transitions.
 func Parse(graph string) ([]Node, error) {
-	lines := strings.Split(graph, "\n")
-	var nodes []Node
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
+	g, err := ParseGraph(graph)
 
-		if line == "" || strings.HasPrefix(line, "stateDiagram") {
+	if err != nil {
+		return nil, err
+	}
+
+	return g.Transitions, nil
+}
+
+// ParseGraph parses a Mermaid state diagram (stateDiagram or stateDiagram-v2).
+// Errors are reported as a *SyntaxError, pointing at the offending line and column.
+func ParseGraph(graph string) (*Graph, error) {
+	p := &parser{
+		lines: strings.Split(graph, "\n"),
+		g: &Graph{
+			Parents:      map[string]string{},
+			Regions:      map[string]int{},
+			Descriptions: map[string]string{},
+			Kinds:        map[string]string{},
+		},
+		seen: map[string]bool{},
+	}
+
+	if err := p.skipFrontMatter(); err != nil {
+		return nil, err
+	}
+
+	for p.lineNo++; p.lineNo <= len(p.lines); p.lineNo++ {
+		if isIgnored(p.lines[p.lineNo-1]) {
 			continue
 		}
 
-		matches := strings.Split(line, "-->")
+		tokens, err := lexLine(p.lines[p.lineNo-1], p.lineNo)
 
-		if len(matches) == 2 {
-			fromStateName := strings.TrimSpace(matches[0])
-			toStateName := strings.TrimSpace(matches[1])
+		if err != nil {
+			return nil, err
+		}
 
-			if fromStateName == "" || toStateName == "" {
-				return nil, fmt.Errorf("invalid transition: %s", line)
-			}
+		if len(tokens) == 0 {
+			continue
+		}
+
+		if err = p.statement(tokens); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(p.scopes) > 0 {
+		return nil, syntaxError(len(p.lines), 1, "composite state not closed: %s", p.scopes[len(p.scopes)-1].name)
+	}
+
+	return p.g, nil
+}
+
+// isIgnored tells if the line is a statement with no meaning for the state machine, styling and accessibility, whose
+// syntax (e.g. CSS) is not lexed.
+func isIgnored(line string) bool {
+	fields := strings.Fields(line)
+
+	if len(fields) == 0 {
+		return false
+	}
+
+	switch strings.SplitN(fields[0], ":", 2)[0] {
+	case "classDef", "class", "style", "accTitle":
+		return true
+	}
+
+	return false
+}
+
+// skipFrontMatter skips the configuration block delimited by `---` lines, if the graph starts with one.
+func (p *parser) skipFrontMatter() error {
+	for i, line := range p.lines {
+		line = strings.TrimSpace(line)
+
+		if line == "" {
+			continue
+		}
 
-			isTerminal := false
-			if toStateName == "[*]" {
-				isTerminal = true
+		if line != "---" {
+			return nil
+		}
+
+		for j := i + 1; j < len(p.lines); j++ {
+			if strings.TrimSpace(p.lines[j]) == "---" {
+				p.lineNo = j + 1
+				return nil
 			}
+		}
+
+		return syntaxError(i+1, 1, "front matter not closed")
+	}
+
+	return nil
+}
+
+func (p *parser) statement(tokens []token) error {
+	first := tokens[0]
 
-			isInitial := false
-			if fromStateName == "[*]" {
-				isInitial = true
+	switch first.kind {
+	case tokRBrace:
+		if len(p.scopes) == 0 {
+			return syntaxError(first.line, first.column, "unexpected end of composite state")
+		}
+
+		p.scopes = p.scopes[:len(p.scopes)-1]
+		return p.expectEnd(tokens[1:])
+	case tokSeparator:
+		if len(p.scopes) == 0 {
+			return syntaxError(first.line, first.column, "concurrent regions are only allowed inside a composite state")
+		}
+
+		p.scopes[len(p.scopes)-1].region++
+		return p.expectEnd(tokens[1:])
+	case tokIdent:
+		switch first.value {
+		case "stateDiagram", "stateDiagram-v2":
+			return p.expectEnd(tokens[1:])
+		case "direction":
+			return p.direction(tokens)
+		case "state":
+			if len(tokens) > 1 && tokens[1].kind != tokArrow && tokens[1].kind != tokText {
+				return p.state(tokens)
+			}
+		case "note":
+			if len(tokens) > 1 && (tokens[1].value == "left" || tokens[1].value == "right") {
+				return p.note(tokens)
 			}
+		case "accDescr":
+			return p.skipBlock(tokens)
+		}
+	}
+
+	return p.transitionOrDescription(tokens)
+}
 
-			nodes = append(nodes, Node{
-				From:             fromStateName,
-				To:               toStateName,
-				SourceIsInitial:  isInitial,
-				TargetIsTerminal: isTerminal,
-			})
-		} else {
-			return nil, fmt.Errorf("invalid transition: %s", line)
+// transitionOrDescription parses `A --> B`, `A --> B : label`, `A : description` and `A`.
+func (p *parser) transitionOrDescription(tokens []token) error {
+	from, rest, err := p.stateRef(tokens)
+
+	if err != nil {
+		return err
+	}
+
+	if len(rest) == 0 {
+		p.declare(from.value)
+		return nil
+	}
+
+	if rest[0].kind == tokText {
+		if from.kind == tokStar {
+			return syntaxError(rest[0].line, rest[0].column, "a description requires a state name")
 		}
+
+		p.declare(from.value)
+		p.g.Descriptions[from.value] = rest[0].value
+		return nil
+	}
+
+	if rest[0].kind != tokArrow {
+		return syntaxError(rest[0].line, rest[0].column, "expected --> but got %s", rest[0].value)
 	}
 
-	return nodes, nil
+	arrow := rest[0]
+
+	if len(rest) == 1 {
+		return syntaxError(arrow.line, arrow.column+3, "missing target state")
+	}
+
+	to, rest, err := p.stateRef(rest[1:])
+
+	if err != nil {
+		return err
+	}
+
+	label := ""
+	if len(rest) > 0 {
+		if rest[0].kind != tokText {
+			return syntaxError(rest[0].line, rest[0].column, "unexpected %s after transition", rest[0].value)
+		}
+
+		label = rest[0].value
+	}
+
+	p.declare(from.value)
+	p.declare(to.value)
+
+	p.g.Transitions = append(p.g.Transitions, Node{
+		From:             from.value,
+		To:               to.value,
+		Label:            label,
+		Parent:           p.parent(),
+		SourceIsInitial:  from.kind == tokStar,
+		TargetIsTerminal: to.kind == tokStar,
+		Line:             from.line,
+	})
+
+	return nil
+}
+
+// stateRef parses a state name or [*], optionally followed by a class shorthand (`A:::someClass`).
+func (p *parser) stateRef(tokens []token) (token, []token, error) {
+	ref := tokens[0]
+
+	if ref.kind != tokIdent && ref.kind != tokStar {
+		return ref, nil, syntaxError(ref.line, ref.column, "expected a state but got %s", ref.value)
+	}
+
+	rest := tokens[1:]
+	if len(rest) > 0 && rest[0].kind == tokClass {
+		rest = rest[1:]
+	}
+
+	return ref, rest, nil
+}
+
+// state parses `state X`, `state "Description" as X`, `state X <<choice>>` and the opening of a composite state
+// `state X {`.
+func (p *parser) state(tokens []token) error {
+	rest := tokens[1:]
+	description := ""
+
+	if rest[0].kind == tokString {
+		description = rest[0].value
+
+		if len(rest) < 3 || rest[1].kind != tokIdent || rest[1].value != "as" || rest[2].kind != tokIdent {
+			return syntaxError(rest[0].line, rest[0].column, `expected state "Description" as Name`)
+		}
+
+		rest = rest[2:]
+	}
+
+	name, rest, err := p.stateRef(rest)
+
+	if err != nil {
+		return err
+	}
+
+	if name.kind == tokStar {
+		return syntaxError(name.line, name.column, "[*] cannot be declared as a state")
+	}
+
+	p.declare(name.value)
+
+	if description != "" {
+		p.g.Descriptions[name.value] = description
+	}
+
+	if len(rest) > 0 && rest[0].kind == tokStereotype {
+		kind := rest[0].value
+
+		if kind != "choice" && kind != "fork" && kind != "join" && kind != "history" && kind != "deepHistory" {
+			return syntaxError(rest[0].line, rest[0].column, "unknown stereotype <<%s>>", kind)
+		}
+
+		p.g.Kinds[name.value] = kind
+		rest = rest[1:]
+	}
+
+	if len(rest) > 0 && rest[0].kind == tokLBrace {
+		p.scopes = append(p.scopes, scope{name: name.value})
+		rest = rest[1:]
+	}
+
+	return p.expectEnd(rest)
+}
+
+// note parses `note left of X : text` and multi-line notes ending with `end note`.
+func (p *parser) note(tokens []token) error {
+	if len(tokens) < 4 || tokens[2].kind != tokIdent || tokens[2].value != "of" || tokens[3].kind != tokIdent {
+		return syntaxError(tokens[0].line, tokens[0].column, "expected note left|right of State")
+	}
+
+	note := Note{Position: tokens[1].value, State: tokens[3].value}
+	rest := tokens[4:]
+
+	if len(rest) > 0 {
+		if rest[0].kind != tokText {
+			return syntaxError(rest[0].line, rest[0].column, "unexpected %s after note", rest[0].value)
+		}
+
+		note.Text = rest[0].value
+		p.g.Notes = append(p.g.Notes, note)
+		return nil
+	}
+
+	start := p.lineNo
+	var text []string
+
+	for p.lineNo++; p.lineNo <= len(p.lines); p.lineNo++ {
+		line := strings.TrimSpace(p.lines[p.lineNo-1])
+
+		if line == "end note" {
+			note.Text = strings.Join(text, "\n")
+			p.g.Notes = append(p.g.Notes, note)
+			return nil
+		}
+
+		text = append(text, line)
+	}
+
+	return syntaxError(start, tokens[0].column, "note not closed, missing end note")
+}
+
+func (p *parser) direction(tokens []token) error {
+	if len(tokens) < 2 || tokens[1].kind != tokIdent {
+		return syntaxError(tokens[0].line, tokens[0].column, "missing direction")
+	}
+
+	switch tokens[1].value {
+	case "LR", "RL", "TB", "BT":
+		if len(p.scopes) == 0 {
+			p.g.Direction = tokens[1].value
+		}
+	default:
+		return syntaxError(tokens[1].line, tokens[1].column, "unknown direction %s", tokens[1].value)
+	}
+
+	return p.expectEnd(tokens[2:])
+}
+
+// skipBlock skips a statement that may span several lines between braces, e.g. `accDescr { ... }`.
+func (p *parser) skipBlock(tokens []token) error {
+	if len(tokens) < 2 || tokens[1].kind != tokLBrace {
+		return nil
+	}
+
+	start := p.lineNo
+
+	for p.lineNo++; p.lineNo <= len(p.lines); p.lineNo++ {
+		if strings.Contains(p.lines[p.lineNo-1], "}") {
+			return nil
+		}
+	}
+
+	return syntaxError(start, tokens[1].column, "block not closed")
+}
+
+func (p *parser) expectEnd(tokens []token) error {
+	if len(tokens) > 0 {
+		return syntaxError(tokens[0].line, tokens[0].column, "unexpected %s", tokens[0].value)
+	}
+
+	return nil
+}
+
+func (p *parser) parent() string {
+	if len(p.scopes) == 0 {
+		return ""
+	}
+
+	return p.scopes[len(p.scopes)-1].name
+}
+
+func (p *parser) declare(name string) {
+	if name == "[*]" || p.seen[name] {
+		return
+	}
+
+	p.seen[name] = true
+	p.g.States = append(p.g.States, name)
+
+	if len(p.scopes) > 0 {
+		s := p.scopes[len(p.scopes)-1]
+		p.g.Parents[name] = s.name
+		p.g.Regions[name] = s.region
+	}
 }
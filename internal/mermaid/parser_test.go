@@ -44,6 +44,46 @@ func TestParse(t *testing.T) {
 			want:    7,
 			wantErr: false,
 		},
+		{
+			name: "with composite states",
+			args: args{
+				graph: `
+				[*] --> A
+				A --> B
+				state B {
+					[*] --> B1
+					B1 --> B2
+					state B2 {
+						[*] --> B21
+					}
+				}
+				B --> [*]`,
+			},
+			want:    6,
+			wantErr: false,
+		},
+		{
+			name: "with error, composite state not closed",
+			args: args{
+				graph: `
+				[*] --> A
+				state A {
+					[*] --> A1`,
+			},
+			wantErr: true,
+		},
+		{
+			name: "with labels",
+			args: args{
+				graph: `
+				[*] --> A
+				A --> B: [isValid]
+				A --> C: Rejected
+				C --> [*]`,
+			},
+			want:    4,
+			wantErr: false,
+		},
 		{
 			name: "with error, missing state name",
 			args: args{
@@ -87,3 +127,164 @@ func TestParse(t *testing.T) {
 		})
 	}
 }
+
+func TestParseGraph_CompositeStates(t *testing.T) {
+	g, err := ParseGraph(`
+		A --> B
+		state B {
+			[*] --> B1
+			B1 --> B2
+			state B2 {
+				[*] --> B21
+			}
+		}`)
+
+	if err != nil {
+		t.Fatalf("ParseGraph() error = %v", err)
+	}
+
+	expected := map[string]string{"B1": "B", "B2": "B", "B21": "B2"}
+	for state, parent := range expected {
+		if g.Parents[state] != parent {
+			t.Errorf("state %s should be inside %s, got %s", state, parent, g.Parents[state])
+		}
+	}
+
+	if _, ok := g.Parents["A"]; ok {
+		t.Error("state A should be at the top level")
+	}
+
+	if g.Transitions[1].Parent != "B" || !g.Transitions[1].SourceIsInitial {
+		t.Error("the initial transition of B should have been recorded")
+	}
+}
+
+func TestParseGraph_FullGrammar(t *testing.T) {
+	g, err := ParseGraph(`
+		---
+		title: Orders
+		---
+		stateDiagram-v2
+		%% A diagram copied from the docs
+		direction LR
+		accTitle: Orders
+		accDescr {
+			The lifecycle
+			of an order
+		}
+		classDef failure fill:#f00
+		state "Waiting for payment" as Pending
+		Paid : The payment was received
+		state check <<choice>>
+		[*] --> Pending
+		Pending --> check : Pay
+		check --> Paid : [isValid]
+		check --> Failed:::failure %% inline comment
+		note right of Pending : Payments expire after 1 day
+		note left of Paid
+			Shipping starts
+			right away
+		end note
+		state Paid {
+			direction TB
+			[*] --> Packing
+			--
+			[*] --> Invoicing
+		}
+		class Failed failure
+		Failed --> [*]
+	`)
+
+	if err != nil {
+		t.Fatalf("ParseGraph() error = %v", err)
+	}
+
+	if g.Direction != "LR" {
+		t.Errorf("direction should be LR, got %s", g.Direction)
+	}
+
+	if len(g.Transitions) != 7 {
+		t.Errorf("unexpected number of transitions, got %d, expected 7", len(g.Transitions))
+	}
+
+	if g.Transitions[1].Label != "Pay" || g.Transitions[2].Label != "[isValid]" || g.Transitions[3].Label != "" {
+		t.Errorf("labels should have been recorded, got %v", g.Transitions)
+	}
+
+	if g.Descriptions["Pending"] != "Waiting for payment" || g.Descriptions["Paid"] != "The payment was received" {
+		t.Errorf("descriptions should have been recorded, got %v", g.Descriptions)
+	}
+
+	if g.Kinds["check"] != "choice" {
+		t.Errorf("check should be a choice, got %s", g.Kinds["check"])
+	}
+
+	if len(g.Notes) != 2 || g.Notes[1].Text != "Shipping starts\nright away" || g.Notes[0].Position != "right" {
+		t.Errorf("notes should have been recorded, got %v", g.Notes)
+	}
+
+	if g.Parents["Invoicing"] != "Paid" || g.Regions["Packing"] != 0 || g.Regions["Invoicing"] != 1 {
+		t.Errorf("concurrent regions should have been recorded, got %v", g.Regions)
+	}
+}
+
+func TestParseGraph_SyntaxErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		graph  string
+		line   int
+		column int
+	}{
+		{
+			name:   "wrong arrow",
+			graph:  "stateDiagram-v2\n  A -> B",
+			line:   2,
+			column: 5,
+		},
+		{
+			name:   "missing target",
+			graph:  "A -->",
+			line:   1,
+			column: 6,
+		},
+		{
+			name:   "unclosed description",
+			graph:  "A --> B\nstate \"Long name as C",
+			line:   2,
+			column: 7,
+		},
+		{
+			name:   "unexpected closing brace",
+			graph:  "A --> B\n}",
+			line:   2,
+			column: 1,
+		},
+		{
+			name:   "unclosed note",
+			graph:  "A --> B\nnote left of A\ntext",
+			line:   2,
+			column: 1,
+		},
+		{
+			name:   "region outside of a composite state",
+			graph:  "A --> B\n--\nB --> C",
+			line:   2,
+			column: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseGraph(tt.graph)
+
+			syntaxErr, ok := err.(*SyntaxError)
+			if !ok {
+				t.Fatalf("ParseGraph() should have returned a SyntaxError, got %v", err)
+			}
+
+			if syntaxErr.Line != tt.line || syntaxErr.Column != tt.column {
+				t.Errorf("error should be at %d:%d, got %d:%d (%v)", tt.line, tt.column, syntaxErr.Line, syntaxErr.Column, err)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package eventhub
 import (
 	"context"
 	"github.com/a-inacio/edt-go/pkg/event"
+	"reflect"
 )
 
 type Handler interface {
@@ -14,13 +15,19 @@ type ActionHandler interface {
 	TargetEvent() event.Event
 }
 
+// HandlerFunc adapts a function to a Handler, e.g. to write Middleware. Every call returns a distinct Handler, that
+// can be used to later unregister from.
+func HandlerFunc(cb func(ctx context.Context, e event.Event) error) Handler {
+	return &callbackHandler{cb: cb}
+}
+
 type callbackHandler struct {
 	cb func(ctx context.Context, e event.Event) error
 	e  event.Event
 }
 
 type handlers struct {
-	callbacks []Handler
+	callbacks []*subscription
 }
 
 func (cbh callbackHandler) Handler(ctx context.Context, e event.Event) error {
@@ -31,6 +38,17 @@ func (cbh callbackHandler) TargetEvent() event.Event {
 	return cbh.e
 }
 
+// sameHandler tells if two handlers are the same, handlers of uncomparable types (e.g. funcs, or structs with a slice
+// field) only being the same when registered through a pointer.
+func sameHandler(a, b Handler) bool {
+	t := reflect.TypeOf(a)
+	if t == nil {
+		return b == nil
+	}
+
+	return t == reflect.TypeOf(b) && t.Comparable() && a == b
+}
+
 func ToHandler(e event.Event, cb func(ctx context.Context, e event.Event) error) ActionHandler {
 	return &callbackHandler{
 		cb: cb,
@@ -0,0 +1,115 @@
+package eventhub
+
+import (
+	"context"
+	"fmt"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"sync"
+	"time"
+)
+
+// DeadLetter is an event a handler failed to handle, after all the attempts of its retry policy.
+type DeadLetter struct {
+	Event event.Event
+	// Handler is the handler that failed.
+	Handler Handler
+	// Subscription is the event name, or the pattern, the handler was registered for.
+	Subscription string
+	Attempts     int
+	// Err is the error of the last attempt.
+	Err  error
+	Time time.Time
+	sub  *subscription
+}
+
+// DeadLetterSink receives the dead letters of a hub, see Config.
+// Put is called from the goroutine delivering the event, so it should not block.
+type DeadLetterSink interface {
+	Put(letter DeadLetter)
+}
+
+// Replay hands a dead letter to its handler again, synchronously, with the retry policy of its subscription.
+// When it fails again, the dead letter goes back to the sink of the hub, with the attempts added up.
+func (h *EventHub) Replay(ctx context.Context, letter DeadLetter) error {
+	sub := letter.sub
+	if sub == nil {
+		sub = newSubscription(letter.Subscription, letter.Handler, nil)
+	}
+
+	return h.deliver(ctx, letter.Event, sub, letter.Attempts)
+}
+
+// DeadLetterQueue is an in memory DeadLetterSink, to inspect and replay dead letters.
+type DeadLetterQueue struct {
+	mu       sync.Mutex
+	letters  []DeadLetter
+	capacity int
+}
+
+// NewDeadLetterQueue creates a DeadLetterQueue keeping up to capacity dead letters, the oldest being discarded first,
+// or all of them when capacity is 0.
+func NewDeadLetterQueue(capacity int) *DeadLetterQueue {
+	return &DeadLetterQueue{capacity: capacity}
+}
+
+// Put adds a dead letter to the queue.
+func (q *DeadLetterQueue) Put(letter DeadLetter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.capacity > 0 && len(q.letters) >= q.capacity {
+		q.letters = append(q.letters[:0:0], q.letters[len(q.letters)-q.capacity+1:]...)
+	}
+
+	q.letters = append(q.letters, letter)
+}
+
+// Letters returns the dead letters in the queue, oldest first.
+func (q *DeadLetterQueue) Letters() []DeadLetter {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return append([]DeadLetter{}, q.letters...)
+}
+
+// Len returns the number of dead letters in the queue.
+func (q *DeadLetterQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.letters)
+}
+
+// Replay takes the dead letters matching the filter (all of them when nil) out of the queue and replays them through
+// the hub, one after the other, see EventHub.Replay. It returns the error of the last one failing again, if any.
+func (q *DeadLetterQueue) Replay(ctx context.Context, hub *EventHub, filter func(letter DeadLetter) bool) error {
+	var replayed []DeadLetter
+
+	q.mu.Lock()
+	kept := q.letters[:0:0]
+	for _, letter := range q.letters {
+		if filter == nil || filter(letter) {
+			replayed = append(replayed, letter)
+		} else {
+			kept = append(kept, letter)
+		}
+	}
+	q.letters = kept
+	q.mu.Unlock()
+
+	var failed int
+	var lastErr error
+
+	for _, letter := range replayed {
+		if err := hub.Replay(ctx, letter); err != nil {
+			failed++
+			lastErr = err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d dead letters failed again: %w", failed, len(replayed), lastErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package eventhub
+
+import (
+	"context"
+	"errors"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type flakyHandler struct {
+	failures int32
+	calls    int32
+}
+
+func (h *flakyHandler) Handler(ctx context.Context, e event.Event) error {
+	if atomic.AddInt32(&h.calls, 1) <= atomic.LoadInt32(&h.failures) {
+		return errors.New("I was asked to fail")
+	}
+
+	return nil
+}
+
+func TestHub_Retry(t *testing.T) {
+	deadLetters := NewDeadLetterQueue(0)
+	hub := NewEventHub(&Config{DeadLetters: deadLetters})
+
+	handler := &flakyHandler{failures: 2}
+	hub.RegisterHandler(SomeEvent{}, handler, WithRetry(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}))
+
+	hub.Publish(SomeEvent{}, nil).Wait()
+
+	if handler.calls != 3 {
+		t.Errorf("The handler should have been called 3 times, got %d", handler.calls)
+	}
+
+	if deadLetters.Len() != 0 {
+		t.Errorf("The event should not have been dead lettered")
+	}
+}
+
+func TestHub_DeadLetters(t *testing.T) {
+	deadLetters := NewDeadLetterQueue(0)
+	hub := NewEventHub(&Config{DeadLetters: deadLetters})
+
+	handler := &flakyHandler{failures: 4}
+	other := &flakyHandler{failures: 1}
+	hub.RegisterHandler(SomeEvent{}, handler, WithRetry(RetryPolicy{MaxAttempts: 2, Backoff: time.Millisecond}))
+	hub.RegisterPatternHandler("*", other)
+
+	hub.Publish(SomeEvent{SomeValue: "42"}, nil).Wait()
+
+	letters := deadLetters.Letters()
+	if len(letters) != 2 {
+		t.Fatalf("There should be 2 dead letters, got %d", len(letters))
+	}
+
+	var letter DeadLetter
+	for _, l := range letters {
+		if l.Handler == handler {
+			letter = l
+		}
+	}
+
+	se, _ := event.ValueOf[SomeEvent](letter.Event)
+	if se.SomeValue != "42" || letter.Subscription != "SomeEvent" || letter.Attempts != 2 || letter.Err == nil {
+		t.Errorf("The dead letter should tell the event, subscription, attempts and error, got %+v", letter)
+	}
+
+	// Replaying the other handler succeeds, the one of the letter fails twice again
+	if err := deadLetters.Replay(nil, hub, nil); err == nil {
+		t.Errorf("Replaying should have failed")
+	}
+
+	letters = deadLetters.Letters()
+	if len(letters) != 1 || letters[0].Handler != handler || letters[0].Attempts != 4 {
+		t.Fatalf("The dead letter failing again should be back, with the attempts added up, got %+v", letters)
+	}
+
+	if err := deadLetters.Replay(nil, hub, func(letter DeadLetter) bool { return letter.Handler == handler }); err != nil {
+		t.Errorf("Replaying should have succeeded, got %v", err)
+	}
+
+	if deadLetters.Len() != 0 {
+		t.Errorf("The dead letters should have been replayed")
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+
+	expected := []time.Duration{10, 20, 40, 50}
+	for i, e := range expected {
+		if d := policy.delay(i + 1); d != e*time.Millisecond {
+			t.Errorf("Retry %d, should have waited %dms, got %v", i+1, e, d)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := policy.delay(2); d < 10*time.Millisecond || d > 20*time.Millisecond {
+			t.Fatalf("The jitter should have shortened the delay by up to half, got %v", d)
+		}
+	}
+}
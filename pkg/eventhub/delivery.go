@@ -0,0 +1,231 @@
+package eventhub
+
+import (
+	"context"
+	"errors"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"sync"
+)
+
+// ErrQueueFull is returned when publishing to a full queue with the OverflowError policy.
+var ErrQueueFull = errors.New("delivery queue is full")
+
+// DeliveryMode defines how the handlers of a published event are called.
+type DeliveryMode int
+
+const (
+	// DeliveryConcurrent calls every handler in its own goroutine, without any ordering nor bound.
+	DeliveryConcurrent DeliveryMode = iota
+	// DeliverySynchronous calls the handlers one after the other, in the goroutine publishing the event, so Publish
+	// only returns once they are all done.
+	DeliverySynchronous
+	// DeliveryOrdered gives every subscription its own queue, so its handler gets the events one at a time, in the order
+	// they were published.
+	DeliveryOrdered
+	// DeliveryPool queues the deliveries to a bounded pool of workers.
+	DeliveryPool
+)
+
+// OverflowPolicy defines what happens when publishing to a full queue.
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits for room in the queue.
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest delivery of the queue to make room.
+	OverflowDropOldest
+	// OverflowDropNewest discards the delivery being published.
+	OverflowDropNewest
+	// OverflowError discards the delivery being published, TryPublish returns ErrQueueFull.
+	OverflowError
+)
+
+// defaultQueueSize is the size of the queues when Config.QueueSize is not set.
+const defaultQueueSize = 1024
+
+// delivery is an event to hand to a handler.
+type delivery struct {
+	ctx  context.Context
+	e    event.Event
+	sub  *subscription
+	wg   *sync.WaitGroup
+	errs *collector
+}
+
+// deliveryQueue is a bounded queue of deliveries, drained by up to a number of workers, started when deliveries are
+// queued and stopped once it is empty, so idle queues cost no goroutine.
+type deliveryQueue struct {
+	mu       sync.Mutex
+	notFull  *sync.Cond
+	items    []delivery
+	size     int
+	overflow OverflowPolicy
+	workers  int
+	running  int
+	run      func(d delivery)
+}
+
+func newDeliveryQueue(size int, workers int, overflow OverflowPolicy, run func(d delivery)) *deliveryQueue {
+	q := &deliveryQueue{size: size, workers: workers, overflow: overflow, run: run}
+	q.notFull = sync.NewCond(&q.mu)
+
+	return q
+}
+
+// push queues a delivery, applying the overflow policy if the queue is full, it returns the delivery discarded to make
+// room, if any.
+func (q *deliveryQueue) push(d delivery) (*delivery, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var dropped *delivery
+
+	for len(q.items) >= q.size && dropped == nil {
+		switch q.overflow {
+		case OverflowDropOldest:
+			oldest := q.items[0]
+			q.items = q.items[1:]
+			dropped = &oldest
+		case OverflowDropNewest:
+			return &d, nil
+		case OverflowError:
+			return nil, ErrQueueFull
+		default:
+			q.notFull.Wait()
+		}
+	}
+
+	q.items = append(q.items, d)
+
+	if q.running < q.workers {
+		q.running++
+		go q.drain()
+	}
+
+	return dropped, nil
+}
+
+func (q *deliveryQueue) drain() {
+	for {
+		q.mu.Lock()
+		if len(q.items) == 0 {
+			q.running--
+			q.mu.Unlock()
+			return
+		}
+
+		d := q.items[0]
+		q.items[0] = delivery{}
+		q.items = q.items[1:]
+		q.notFull.Signal()
+		q.mu.Unlock()
+
+		q.run(d)
+	}
+}
+
+// TryPublish publishes an event like Publish does, but returns an error wrapping ErrQueueFull when some of the
+// deliveries were rejected by the OverflowError policy, the other handlers still get the event, or the error of the
+// publish middleware.
+func (h *EventHub) TryPublish(e event.Event, ctx context.Context) (*sync.WaitGroup, error) {
+	return h.publish(e, ctx, nil)
+}
+
+// publish runs the publish middleware, which in turn hands the event to its handlers, reporting the errors of the
+// deliveries and of the middleware to the collector, if any.
+func (h *EventHub) publish(e event.Event, ctx context.Context, c *collector) (*sync.WaitGroup, error) {
+	var wg sync.WaitGroup
+	var dispatchErr error
+
+	h.mu.Lock()
+	middleware := h.publishMiddleware
+	h.mu.Unlock()
+
+	dispatch := HandlerFunc(func(ctx context.Context, e event.Event) error {
+		dispatchErr = h.dispatch(e, ctx, &wg, c)
+		return dispatchErr
+	})
+
+	err := chain(dispatch, middleware).Handler(ctx, e)
+
+	// The errors of the deliveries are already collected
+	if err != nil && err != dispatchErr {
+		c.add(err)
+	}
+
+	return &wg, err
+}
+
+// dispatch hands an event to its handlers, as the delivery mode says, returning the first error of the deliveries
+// rejected because of a full queue.
+func (h *EventHub) dispatch(e event.Event, ctx context.Context, wg *sync.WaitGroup, c *collector) error {
+	callbacks := h.callbacks(event.GetName(e))
+
+	if callbacks == nil {
+		return nil
+	}
+
+	wg.Add(len(callbacks))
+
+	var err error
+
+	for _, callback := range callbacks {
+		d := delivery{ctx: ctx, e: e, sub: callback, wg: wg, errs: c}
+
+		switch h.delivery {
+		case DeliverySynchronous:
+			h.run(d)
+		case DeliveryOrdered:
+			err = h.enqueue(h.orderedQueue(callback), d, err)
+		case DeliveryPool:
+			err = h.enqueue(h.pool, d, err)
+		default:
+			go h.run(d)
+		}
+	}
+
+	return err
+}
+
+// enqueue queues a delivery, returning the first error of the deliveries of an event.
+func (h *EventHub) enqueue(q *deliveryQueue, d delivery, err error) error {
+	dropped, pushErr := q.push(d)
+
+	if dropped != nil {
+		h.l.Warn("Event dropped", "event", event.GetName(dropped.e), "reason", ErrQueueFull)
+		dropped.errs.add(ErrQueueFull)
+		dropped.wg.Done()
+	}
+
+	if pushErr != nil {
+		d.errs.add(pushErr)
+		d.wg.Done()
+
+		if err == nil {
+			err = pushErr
+		}
+	}
+
+	return err
+}
+
+// run calls the handler of a delivery.
+func (h *EventHub) run(d delivery) {
+	defer d.wg.Done()
+
+	d.errs.add(h.deliver(d.ctx, d.e, d.sub, 0))
+}
+
+// orderedQueue returns the queue of a subscription, for the DeliveryOrdered mode.
+func (h *EventHub) orderedQueue(sub *subscription) *deliveryQueue {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	q, ok := h.queues[sub]
+	if !ok {
+		q = newDeliveryQueue(h.queueSize, 1, h.overflow, h.run)
+		h.queues[sub] = q
+	}
+
+	return q
+}
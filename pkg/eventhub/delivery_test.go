@@ -0,0 +1,223 @@
+package eventhub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type NumberedEvent struct {
+	Number int
+}
+
+type blockingHandler struct {
+	started chan int
+	release chan struct{}
+	mu      sync.Mutex
+	got     []int
+}
+
+func newBlockingHandler() *blockingHandler {
+	return &blockingHandler{started: make(chan int, 16), release: make(chan struct{})}
+}
+
+func (h *blockingHandler) Handler(ctx context.Context, e event.Event) error {
+	n, _ := event.ValueOf[NumberedEvent](e)
+
+	h.started <- n.Number
+	<-h.release
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.got = append(h.got, n.Number)
+	return nil
+}
+
+func TestHub_SynchronousDelivery(t *testing.T) {
+	hub := NewEventHub(&Config{Delivery: DeliverySynchronous})
+
+	handler := &SomeEventHandler{}
+	hub.RegisterHandler(SomeEvent{}, handler)
+
+	hub.Publish(SomeEvent{}, nil)
+
+	if !handler.GotCalled {
+		t.Errorf("The handler should have been called before Publish returned")
+	}
+}
+
+func TestHub_OrderedDelivery(t *testing.T) {
+	hub := NewEventHub(&Config{Delivery: DeliveryOrdered})
+
+	var mu sync.Mutex
+	var got []int
+
+	hub.RegisterHandler(NumberedEvent{}, ToHandler(NumberedEvent{}, func(ctx context.Context, e event.Event) error {
+		n, _ := event.ValueOf[NumberedEvent](e)
+
+		// Let the later events catch up
+		time.Sleep(time.Duration(n.Number%3) * time.Millisecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		got = append(got, n.Number)
+		return nil
+	}))
+
+	var wgs []*sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wgs = append(wgs, hub.Publish(NumberedEvent{Number: i}, nil))
+	}
+
+	for _, wg := range wgs {
+		wg.Wait()
+	}
+
+	for i, n := range got {
+		if n != i {
+			t.Fatalf("The events should have been handled in order, got %v", got)
+		}
+	}
+
+	if len(got) != 50 {
+		t.Errorf("The handler should have got 50 events, got %d", len(got))
+	}
+}
+
+// unhashableHandler is a handler registered by value, whose slice field makes it unhashable
+type unhashableHandler struct {
+	tags []string
+	got  chan int
+}
+
+func (h unhashableHandler) Handler(ctx context.Context, e event.Event) error {
+	n, _ := event.ValueOf[NumberedEvent](e)
+	h.got <- n.Number
+	return nil
+}
+
+func TestHub_OrderedDelivery_UnhashableHandler(t *testing.T) {
+	hub := NewEventHub(&Config{Delivery: DeliveryOrdered})
+
+	handler := unhashableHandler{tags: []string{"unhashable"}, got: make(chan int, 10)}
+	hub.RegisterHandler(NumberedEvent{}, handler)
+
+	for i := 0; i < 10; i++ {
+		hub.Publish(NumberedEvent{Number: i}, nil)
+	}
+
+	for i := 0; i < 10; i++ {
+		if n := <-handler.got; n != i {
+			t.Fatalf("The events should have been handled in order, got %d instead of %d", n, i)
+		}
+	}
+}
+
+func TestHub_PoolDelivery(t *testing.T) {
+	hub := NewEventHub(&Config{Delivery: DeliveryPool, Workers: 2})
+
+	var running, max int32
+
+	for i := 0; i < 5; i++ {
+		hub.RegisterHandler(SomeEvent{}, ToHandler(SomeEvent{}, func(ctx context.Context, e event.Event) error {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+
+			for {
+				m := atomic.LoadInt32(&max)
+				if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+					break
+				}
+			}
+
+			time.Sleep(5 * time.Millisecond)
+			return nil
+		}))
+	}
+
+	for i := 0; i < 10; i++ {
+		hub.Publish(SomeEvent{}, nil).Wait()
+	}
+
+	if max > 2 {
+		t.Errorf("There should have been at most 2 handlers running at once, got %d", max)
+	}
+}
+
+func TestHub_Overflow(t *testing.T) {
+	cases := []struct {
+		overflow OverflowPolicy
+		expected string
+	}{
+		{OverflowDropNewest, "[1 2]"},
+		{OverflowDropOldest, "[1 3]"},
+		{OverflowError, "[1 2]"},
+	}
+
+	for _, c := range cases {
+		hub := NewEventHub(&Config{Delivery: DeliveryPool, Workers: 1, QueueSize: 1, Overflow: c.overflow})
+
+		handler := newBlockingHandler()
+		hub.RegisterHandler(NumberedEvent{}, handler)
+
+		first := hub.Publish(NumberedEvent{Number: 1}, nil)
+		<-handler.started
+
+		second := hub.Publish(NumberedEvent{Number: 2}, nil)
+		third, err := hub.TryPublish(NumberedEvent{Number: 3}, nil)
+
+		if c.overflow == OverflowError && !errors.Is(err, ErrQueueFull) {
+			t.Errorf("Publishing to a full queue should have failed, got %v", err)
+		}
+
+		if c.overflow != OverflowError && err != nil {
+			t.Errorf("Publishing to a full queue should have dropped an event, got %v", err)
+		}
+
+		close(handler.release)
+
+		first.Wait()
+		second.Wait()
+		third.Wait()
+
+		if got := fmt.Sprint(handler.got); got != c.expected {
+			t.Errorf("Overflow policy %d, the handler should have got %s, got %s", c.overflow, c.expected, got)
+		}
+	}
+}
+
+func TestHub_OverflowBlock(t *testing.T) {
+	hub := NewEventHub(&Config{Delivery: DeliveryOrdered, QueueSize: 1})
+
+	handler := newBlockingHandler()
+	hub.RegisterHandler(NumberedEvent{}, handler)
+
+	hub.Publish(NumberedEvent{Number: 1}, nil)
+	<-handler.started
+	hub.Publish(NumberedEvent{Number: 2}, nil)
+
+	published := make(chan *sync.WaitGroup)
+	go func() {
+		published <- hub.Publish(NumberedEvent{Number: 3}, nil)
+	}()
+
+	select {
+	case <-published:
+		t.Fatal("Publishing to a full queue should have blocked")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(handler.release)
+	(<-published).Wait()
+
+	if got := fmt.Sprint(handler.got); got != "[1 2 3]" {
+		t.Errorf("The handler should have got every event, got %s", got)
+	}
+}
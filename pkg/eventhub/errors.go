@@ -0,0 +1,116 @@
+package eventhub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// ErrHandlerPanicked is wrapped by the PanicError of a handler that panicked.
+var ErrHandlerPanicked = errors.New("event handler panicked")
+
+// PanicError is the error a handler that panicked fails with, the hub recovering from the panic.
+type PanicError struct {
+	// Value is the value the handler panicked with.
+	Value any
+	// Stack is the stack trace of the goroutine that panicked.
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%v: %v", ErrHandlerPanicked, e.Value)
+}
+
+func (e *PanicError) Unwrap() []error {
+	if err, ok := e.Value.(error); ok {
+		return []error{ErrHandlerPanicked, err}
+	}
+
+	return []error{ErrHandlerPanicked}
+}
+
+// HandlerErrors gathers the errors of the handlers of an event, see PublishAndCollect.
+type HandlerErrors struct {
+	Errors []error
+}
+
+func (e *HandlerErrors) Error() string {
+	msgs := make([]string, len(e.Errors))
+
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+
+	return fmt.Sprintf("%d event handler(s) failed: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+func (e *HandlerErrors) Unwrap() []error {
+	return e.Errors
+}
+
+// Has tells if any of the errors is the given error (e.g. ErrHandlerPanicked).
+func (e *HandlerErrors) Has(target error) bool {
+	for _, err := range e.Errors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// collector gathers the errors of the deliveries of an event.
+type collector struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func (c *collector) add(err error) {
+	if c == nil || err == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.errs = append(c.errs, err)
+}
+
+func (c *collector) err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.errs) == 0 {
+		return nil
+	}
+
+	return &HandlerErrors{Errors: append([]error{}, c.errs...)}
+}
+
+// PublishAndCollect publishes an event, waits for all its handlers, and returns their errors as a *HandlerErrors, nil
+// if none failed. Deliveries dropped or rejected because of a full queue report ErrQueueFull.
+func (h *EventHub) PublishAndCollect(e event.Event, ctx context.Context) error {
+	c := &collector{}
+
+	wg, _ := h.publish(e, ctx, c)
+	wg.Wait()
+
+	return c.err()
+}
+
+// call calls a handler, turning a panic into a *PanicError.
+func (h *EventHub) call(ctx context.Context, e event.Event, handler Handler) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			stack := debug.Stack()
+			h.l.Error("Event handler panicked", "event", event.GetName(e), "reason", v, "stack", string(stack))
+			err = &PanicError{Value: v, Stack: stack}
+		}
+	}()
+
+	return handler.Handler(ctx, e)
+}
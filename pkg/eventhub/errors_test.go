@@ -0,0 +1,82 @@
+package eventhub
+
+import (
+	"context"
+	"errors"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var errBoom = errors.New("boom")
+
+func TestHub_PanickingHandler(t *testing.T) {
+	var mu sync.Mutex
+	var reported []error
+
+	hub := NewEventHub(&Config{OnHandlerError: func(ctx context.Context, e event.Event, handler Handler, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		reported = append(reported, err)
+	}})
+
+	panicking := ToHandler(SomeEvent{}, func(ctx context.Context, e event.Event) error {
+		panic(errBoom)
+	})
+	someEventHandler := &SomeEventHandler{}
+
+	hub.RegisterHandler(SomeEvent{}, panicking)
+	hub.RegisterHandler(SomeEvent{}, someEventHandler)
+
+	err := hub.PublishAndCollect(SomeEvent{}, nil)
+
+	var handlerErrors *HandlerErrors
+	if !errors.As(err, &handlerErrors) || len(handlerErrors.Errors) != 1 || !handlerErrors.Has(ErrHandlerPanicked) {
+		t.Fatalf("The panic should have been collected, got %v", err)
+	}
+
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) || !errors.Is(err, errBoom) {
+		t.Fatalf("The panic should have been turned into a *PanicError wrapping its value, got %v", err)
+	}
+
+	if !strings.Contains(string(panicErr.Stack), "errors_test.go") {
+		t.Errorf("The error should have the stack trace of the panic, got %s", panicErr.Stack)
+	}
+
+	if panicErr.Error() != "event handler panicked: boom" {
+		t.Errorf("The error message should not include the stack trace, got %s", panicErr.Error())
+	}
+
+	if !someEventHandler.GotCalled {
+		t.Errorf("The other handler should have been called")
+	}
+
+	if len(reported) != 1 || !errors.Is(reported[0], ErrHandlerPanicked) {
+		t.Errorf("The panic should have been reported to OnHandlerError, got %v", reported)
+	}
+}
+
+func TestHub_PublishAndCollect(t *testing.T) {
+	hub := NewEventHub(&Config{Delivery: DeliveryPool, Workers: 2})
+
+	hub.RegisterHandler(SomeEvent{}, &SomeEventHandler{})
+	hub.RegisterHandler(SomeEvent{}, &SomeEventHandler{})
+
+	if err := hub.PublishAndCollect(SomeEvent{}, nil); err != nil {
+		t.Errorf("No handler should have failed, got %v", err)
+	}
+
+	err := hub.PublishAndCollect(SomeEvent{ShouldFail: true}, nil)
+
+	var handlerErrors *HandlerErrors
+	if !errors.As(err, &handlerErrors) || len(handlerErrors.Errors) != 2 {
+		t.Errorf("Both handlers should have failed, got %v", err)
+	}
+
+	if err := hub.PublishAndCollect(SomeOtherEvent{}, nil); err != nil {
+		t.Errorf("An event without handlers should not fail, got %v", err)
+	}
+}
@@ -7,43 +7,93 @@ import (
 	"github.com/a-inacio/rosetta-logger-go/pkg/logger"
 	"github.com/a-inacio/rosetta-logger-go/pkg/rosetta"
 	"reflect"
+	"runtime"
 	"sync"
 )
 
 type EventHub struct {
-	mu            sync.Mutex
-	l             logger.Logger
-	subscriptions map[string]handlers
+	mu                sync.Mutex
+	l                 logger.Logger
+	subscriptions     map[string]handlers
+	patterns          *patternNode
+	delivery          DeliveryMode
+	overflow          OverflowPolicy
+	queueSize         int
+	pool              *deliveryQueue
+	queues            map[*subscription]*deliveryQueue
+	deadLetters       DeadLetterSink
+	onHandlerError    func(ctx context.Context, e event.Event, handler Handler, err error)
+	publishMiddleware []Middleware
+	handlerMiddleware []Middleware
 }
 
 type Config struct {
 	Logger logger.Logger
+	// Delivery defines how the handlers are called, DeliveryConcurrent by default.
+	Delivery DeliveryMode
+	// Workers is the number of workers of the DeliveryPool mode, the number of CPUs by default.
+	Workers int
+	// QueueSize bounds the queue of the DeliveryPool mode, and the one of every handler in the DeliveryOrdered mode,
+	// 1024 by default.
+	QueueSize int
+	// Overflow defines what happens when publishing to a full queue, OverflowBlock by default.
+	// Beware that with OverflowBlock, a handler publishing to its own full queue waits forever.
+	Overflow OverflowPolicy
+	// DeadLetters receives the events handlers failed to handle, see RetryPolicy.
+	DeadLetters DeadLetterSink
+	// OnHandlerError is called with the error of a handler, once all the attempts of its retry policy failed, from
+	// the goroutine delivering the event. A handler that panicked fails with a *PanicError.
+	OnHandlerError func(ctx context.Context, e event.Event, handler Handler, err error)
 }
 
 // NewEventHub creates a new EventHub instance
 func NewEventHub(config *Config) *EventHub {
 	logger := rosetta.NewLogger(logger.NullLoggerType)
+	workers := runtime.NumCPU()
+
+	h := &EventHub{
+		subscriptions: make(map[string]handlers),
+		queues:        make(map[*subscription]*deliveryQueue),
+		queueSize:     defaultQueueSize,
+	}
 
 	if config != nil {
 		if config.Logger != nil {
 			logger = config.Logger
 		}
+
+		if config.Workers > 0 {
+			workers = config.Workers
+		}
+
+		if config.QueueSize > 0 {
+			h.queueSize = config.QueueSize
+		}
+
+		h.delivery = config.Delivery
+		h.overflow = config.Overflow
+		h.deadLetters = config.DeadLetters
+		h.onHandlerError = config.OnHandlerError
 	}
-	return &EventHub{subscriptions: make(map[string]handlers), l: logger}
+
+	h.l = logger
+	h.pool = newDeliveryQueue(h.queueSize, workers, h.overflow, h.run)
+
+	return h
 }
 
 // RegisterHandler registers a handler for an event
-func (h *EventHub) RegisterHandler(e event.Event, handler Handler) {
+func (h *EventHub) RegisterHandler(e event.Event, handler Handler, options ...SubscriptionOption) {
 	eventName := event.GetName(e)
 
 	h.mu.Lock()
 
 	subscriptions, contains := h.subscriptions[eventName]
 	if !contains {
-		subscriptions = handlers{callbacks: make([]Handler, 0)}
+		subscriptions = handlers{callbacks: make([]*subscription, 0)}
 	}
 
-	subscriptions.callbacks = append(subscriptions.callbacks, handler)
+	subscriptions.callbacks = append(subscriptions.callbacks, newSubscription(eventName, handler, options))
 	h.subscriptions[eventName] = subscriptions
 
 	h.mu.Unlock()
@@ -57,13 +107,16 @@ func (h *EventHub) UnregisterHandler(e event.Event, handler Handler) {
 
 	subscriptions, contains := h.subscriptions[eventName]
 	if contains && len(subscriptions.callbacks) > 0 {
-		callbacks := subscriptions.callbacks
+		callbacks := make([]*subscription, 0, len(subscriptions.callbacks))
 
-		// remove handler
-		for idx, v := range callbacks {
-			if v == handler {
-				callbacks = append(callbacks[0:idx], callbacks[idx+1:]...)
+		// remove handler, forgetting the queue of its subscription, the deliveries already queued are still made
+		for _, v := range subscriptions.callbacks {
+			if sameHandler(v.handler, handler) {
+				delete(h.queues, v)
+				continue
 			}
+
+			callbacks = append(callbacks, v)
 		}
 
 		subscriptions.callbacks = callbacks
@@ -73,46 +126,38 @@ func (h *EventHub) UnregisterHandler(e event.Event, handler Handler) {
 	h.mu.Unlock()
 }
 
-// Publish publishes an event
+// Publish publishes an event, the returned WaitGroup is done once every handler got it, or its delivery was dropped.
+// How handlers are called depends on the delivery mode of the hub, see Config.
 func (h *EventHub) Publish(e event.Event, ctx context.Context) *sync.WaitGroup {
-	log := h.l
-	eventName := event.GetName(e)
+	wg, err := h.TryPublish(e, ctx)
+	if err != nil {
+		h.l.Warn("Event not delivered", "event", event.GetName(e), "reason", err)
+	}
 
-	var wg sync.WaitGroup
+	return wg
+}
 
-	var callbacks []Handler
+// callbacks returns the subscriptions of an event, registered for its name or a matching pattern.
+func (h *EventHub) callbacks(eventName string) []*subscription {
+	var callbacks []*subscription
 
 	h.mu.Lock()
+	defer h.mu.Unlock()
 
 	subscriptions, contains := h.subscriptions[eventName]
 	if contains && len(subscriptions.callbacks) > 0 {
 		callbacks = subscriptions.callbacks
 	}
 
-	h.mu.Unlock()
-
-	if callbacks == nil {
-		return &wg
-	}
-
-	wg.Add(len(callbacks))
-
-	for _, value := range callbacks {
-		callback := value // capture the value for the closure!
-		go func() {
-			defer wg.Done()
-			err := callback.Handler(ctx, e)
-			if err != nil {
-				log.Warn("Event handler failed", "reason", err)
-			}
-		}()
+	if patternCallbacks := h.patternCallbacks(eventName); len(patternCallbacks) > 0 {
+		callbacks = append(append([]*subscription{}, callbacks...), patternCallbacks...)
 	}
 
-	return &wg
+	return callbacks
 }
 
 // Subscribe subscribes to an event, returning an ActionHandler that can be used to later unsubscribe from.
-func (h *EventHub) Subscribe(e event.Event, action action.Action) ActionHandler {
+func (h *EventHub) Subscribe(e event.Event, action action.Action, options ...SubscriptionOption) ActionHandler {
 	handler := ToHandler(e, func(ctx context.Context, e event.Event) error {
 		if ctx == nil {
 			ctx = context.Background()
@@ -126,7 +171,7 @@ func (h *EventHub) Subscribe(e event.Event, action action.Action) ActionHandler
 		return err
 	})
 
-	h.RegisterHandler(e, handler)
+	h.RegisterHandler(e, handler, options...)
 
 	return handler
 }
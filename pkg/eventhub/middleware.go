@@ -0,0 +1,45 @@
+package eventhub
+
+// Middleware wraps a Handler with cross-cutting behaviour (tracing, metrics, validation, filtering...).
+// It may call the next handler with a modified or enriched event or context, or not call it at all to drop the event.
+// The context is the one given to Publish, which may be nil.
+type Middleware func(next Handler) Handler
+
+// UsePublish adds middleware run on every publish, in the goroutine publishing the event, before it is handed to
+// its handlers: the next handler dispatches the event it gets, by its name, and returns an error wrapping
+// ErrQueueFull when deliveries were rejected. Middleware registered first runs first.
+func (h *EventHub) UsePublish(middleware ...Middleware) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.publishMiddleware = append(h.publishMiddleware[:len(h.publishMiddleware):len(h.publishMiddleware)], middleware...)
+}
+
+// UseHandler adds middleware run on every delivery, wrapping the handlers of all the subscriptions, and the
+// middleware of their own (see WithMiddleware). It runs once per attempt, when retrying.
+// Middleware registered first runs first.
+func (h *EventHub) UseHandler(middleware ...Middleware) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.handlerMiddleware = append(h.handlerMiddleware[:len(h.handlerMiddleware):len(h.handlerMiddleware)], middleware...)
+}
+
+// handlerChain returns the handler of a subscription wrapped with the handler middleware of the hub, and the one of
+// the subscription.
+func (h *EventHub) handlerChain(sub *subscription) Handler {
+	h.mu.Lock()
+	middleware := h.handlerMiddleware
+	h.mu.Unlock()
+
+	return chain(chain(sub.handler, sub.middleware), middleware)
+}
+
+// chain wraps a handler with middleware, the first one being the outermost.
+func chain(handler Handler, middleware []Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	return handler
+}
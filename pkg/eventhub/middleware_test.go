@@ -0,0 +1,134 @@
+package eventhub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"testing"
+)
+
+type tenantKey struct{}
+
+func TestHub_PublishMiddleware(t *testing.T) {
+	hub := NewEventHub(&Config{Delivery: DeliverySynchronous})
+
+	errInvalid := errors.New("invalid event")
+
+	// Drops the events of other tenants, rejects the failing ones and enriches the others
+	hub.UsePublish(func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, e event.Event) error {
+			if ctx == nil || ctx.Value(tenantKey{}) != "acme" {
+				return nil
+			}
+
+			return next.Handler(ctx, e)
+		})
+	}, func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, e event.Event) error {
+			se, _ := event.ValueOf[SomeEvent](e)
+			if se.ShouldFail {
+				return errInvalid
+			}
+
+			se.SomeValue = fmt.Sprintf("%s, enriched", se.SomeValue)
+			return next.Handler(ctx, *se)
+		})
+	})
+
+	var got []string
+	hub.RegisterHandler(SomeEvent{}, ToHandler(SomeEvent{}, func(ctx context.Context, e event.Event) error {
+		se, _ := event.ValueOf[SomeEvent](e)
+		got = append(got, se.SomeValue)
+		return nil
+	}))
+
+	acme := context.WithValue(context.Background(), tenantKey{}, "acme")
+
+	hub.Publish(SomeEvent{SomeValue: "42"}, acme).Wait()
+	hub.Publish(SomeEvent{SomeValue: "other tenant"}, context.WithValue(context.Background(), tenantKey{}, "other")).Wait()
+	hub.Publish(SomeEvent{SomeValue: "no tenant"}, nil).Wait()
+
+	if fmt.Sprint(got) != "[42, enriched]" {
+		t.Errorf("The handler should have got the enriched event of the tenant only, got %v", got)
+	}
+
+	if _, err := hub.TryPublish(SomeEvent{ShouldFail: true}, acme); !errors.Is(err, errInvalid) {
+		t.Errorf("The error of the middleware should have been returned, got %v", err)
+	}
+
+	if err := hub.PublishAndCollect(SomeEvent{ShouldFail: true}, acme); !errors.Is(err, errInvalid) {
+		t.Errorf("The error of the middleware should have been collected, got %v", err)
+	}
+}
+
+func TestHub_HandlerMiddleware(t *testing.T) {
+	hub := NewEventHub(&Config{Delivery: DeliverySynchronous})
+
+	var calls []string
+
+	record := func(name string) {
+		calls = append(calls, name)
+	}
+
+	trace := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return HandlerFunc(func(ctx context.Context, e event.Event) error {
+				record(name)
+				return next.Handler(ctx, e)
+			})
+		}
+	}
+
+	drop := func(next Handler) Handler {
+		return HandlerFunc(func(ctx context.Context, e event.Event) error {
+			return nil
+		})
+	}
+
+	hub.UseHandler(trace("global"))
+
+	hub.RegisterHandler(SomeEvent{}, ToHandler(SomeEvent{}, func(ctx context.Context, e event.Event) error {
+		record("handler")
+		return nil
+	}), WithMiddleware(trace("subscription")))
+
+	dropped := &SomeEventHandler{}
+	hub.RegisterHandler(SomeEvent{}, dropped, WithMiddleware(drop))
+
+	hub.Publish(SomeEvent{}, nil).Wait()
+
+	if fmt.Sprint(calls) != "[global subscription handler global]" {
+		t.Errorf("The global middleware should wrap the one of the subscription, got %v", calls)
+	}
+
+	if dropped.GotCalled {
+		t.Errorf("The event should have been dropped by the middleware")
+	}
+}
+
+func TestHub_UnregisterHandlerFunc(t *testing.T) {
+	hub := NewEventHub(nil)
+
+	called := false
+	handler := HandlerFunc(func(ctx context.Context, e event.Event) error {
+		called = true
+		return nil
+	})
+
+	hub.RegisterHandler(SomeEvent{}, handler)
+	hub.RegisterPatternHandler("**", handler)
+
+	// Handlers of uncomparable types are never the same as another one
+	hub.RegisterHandler(SomeOtherEvent{}, unhashableHandler{})
+	hub.UnregisterHandler(SomeOtherEvent{}, unhashableHandler{})
+
+	hub.UnregisterHandler(SomeEvent{}, handler)
+	hub.UnregisterPatternHandler("**", handler)
+
+	hub.Publish(SomeEvent{}, nil).Wait()
+
+	if called {
+		t.Errorf("The handler should have been unregistered")
+	}
+}
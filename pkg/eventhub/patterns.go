@@ -0,0 +1,196 @@
+package eventhub
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidPattern is returned when registering a handler on a malformed pattern.
+var ErrInvalidPattern = errors.New("invalid pattern")
+
+const (
+	// segmentSeparator splits hierarchical event names, e.g. `orders.created.eu`.
+	segmentSeparator = "."
+	// wildcard matches exactly one segment of an event name, e.g. `Order.*` matches `Order.Created`.
+	wildcard = "*"
+	// multiWildcard matches any number of segments, none included, e.g. `orders.**` matches `orders` and
+	// `orders.created.eu`. On its own, it matches every event.
+	multiWildcard = "**"
+)
+
+// patternNode is a node of the trie of pattern subscriptions, one level per segment, so matching an event name only
+// walks the branches that can match it whatever the number of subscriptions.
+type patternNode struct {
+	children  map[string]*patternNode
+	wildcard  *patternNode
+	multi     *patternNode
+	callbacks []*subscription
+}
+
+// RegisterPatternHandler registers a handler for every event whose name matches the pattern.
+// Event names are split in segments by dots (e.g. `orders.created.eu`, or `Order.Created`), a `*` segment matches
+// exactly one segment and a `**` segment any number of them, so `**` alone catches every event.
+func (h *EventHub) RegisterPatternHandler(pattern string, handler Handler, options ...SubscriptionOption) error {
+	segments, err := parsePattern(pattern)
+
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.patterns == nil {
+		h.patterns = &patternNode{}
+	}
+
+	n := h.patterns
+	for _, segment := range segments {
+		n = n.child(segment)
+	}
+
+	n.callbacks = append(n.callbacks, newSubscription(pattern, handler, options))
+
+	return nil
+}
+
+// UnregisterPatternHandler unregisters a handler registered for a pattern.
+func (h *EventHub) UnregisterPatternHandler(pattern string, handler Handler) {
+	segments, err := parsePattern(pattern)
+
+	if err != nil {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	n := h.patterns
+	for _, segment := range segments {
+		if n == nil {
+			return
+		}
+
+		n = n.find(segment)
+	}
+
+	if n == nil {
+		return
+	}
+
+	for idx, v := range n.callbacks {
+		if sameHandler(v.handler, handler) {
+			n.callbacks = append(n.callbacks[:idx:idx], n.callbacks[idx+1:]...)
+			delete(h.queues, v)
+			return
+		}
+	}
+}
+
+// RegisterCatchAllHandler registers a handler for every event, the same as the `**` pattern.
+func (h *EventHub) RegisterCatchAllHandler(handler Handler, options ...SubscriptionOption) {
+	_ = h.RegisterPatternHandler(multiWildcard, handler, options...)
+}
+
+// UnregisterCatchAllHandler unregisters a handler registered by RegisterCatchAllHandler.
+func (h *EventHub) UnregisterCatchAllHandler(handler Handler) {
+	h.UnregisterPatternHandler(multiWildcard, handler)
+}
+
+func parsePattern(pattern string) ([]string, error) {
+	segments := strings.Split(pattern, segmentSeparator)
+
+	for _, segment := range segments {
+		if segment == "" {
+			return nil, fmt.Errorf("%w: empty segment in %q", ErrInvalidPattern, pattern)
+		}
+
+		if segment != wildcard && segment != multiWildcard && strings.Contains(segment, wildcard) {
+			return nil, fmt.Errorf("%w: wildcards must be whole segments in %q", ErrInvalidPattern, pattern)
+		}
+	}
+
+	return segments, nil
+}
+
+func (n *patternNode) child(segment string) *patternNode {
+	switch segment {
+	case wildcard:
+		if n.wildcard == nil {
+			n.wildcard = &patternNode{}
+		}
+		return n.wildcard
+	case multiWildcard:
+		if n.multi == nil {
+			n.multi = &patternNode{}
+		}
+		return n.multi
+	}
+
+	if n.children == nil {
+		n.children = map[string]*patternNode{}
+	}
+
+	c, ok := n.children[segment]
+	if !ok {
+		c = &patternNode{}
+		n.children[segment] = c
+	}
+
+	return c
+}
+
+func (n *patternNode) find(segment string) *patternNode {
+	switch segment {
+	case wildcard:
+		return n.wildcard
+	case multiWildcard:
+		return n.multi
+	}
+
+	return n.children[segment]
+}
+
+// match collects the nodes of the patterns matching the remaining segments of an event name, each node once even
+// when several `**` can match it in different ways. It must be called while holding the lock of the hub.
+func (n *patternNode) match(segments []string, matched []*patternNode, seen map[*patternNode]bool) []*patternNode {
+	if n == nil {
+		return matched
+	}
+
+	if len(segments) == 0 && !seen[n] {
+		seen[n] = true
+		matched = append(matched, n)
+	}
+
+	// `**` consumes any number of the remaining segments, none included
+	if n.multi != nil {
+		for i := 0; i <= len(segments); i++ {
+			matched = n.multi.match(segments[i:], matched, seen)
+		}
+	}
+
+	if len(segments) > 0 {
+		matched = n.children[segments[0]].match(segments[1:], matched, seen)
+		matched = n.wildcard.match(segments[1:], matched, seen)
+	}
+
+	return matched
+}
+
+// patternCallbacks returns the subscriptions of the patterns matching an event name, it must be called while holding
+// the lock of the hub.
+func (h *EventHub) patternCallbacks(eventName string) []*subscription {
+	if h.patterns == nil {
+		return nil
+	}
+
+	var callbacks []*subscription
+
+	for _, n := range h.patterns.match(strings.Split(eventName, segmentSeparator), nil, map[*patternNode]bool{}) {
+		callbacks = append(callbacks, n.callbacks...)
+	}
+
+	return callbacks
+}
@@ -0,0 +1,118 @@
+package eventhub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"sort"
+	"sync"
+	"testing"
+)
+
+type recordingHandler struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (h *recordingHandler) Handler(ctx context.Context, e event.Event) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.events = append(h.events, event.GetName(e))
+	return nil
+}
+
+func (h *recordingHandler) received() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	received := append([]string{}, h.events...)
+	sort.Strings(received)
+
+	return received
+}
+
+func TestHub_PatternHandlers(t *testing.T) {
+	hub := NewEventHub(nil)
+
+	orders := &recordingHandler{}
+	created := &recordingHandler{}
+	all := &recordingHandler{}
+	single := &recordingHandler{}
+
+	hub.RegisterPatternHandler("orders.**", orders)
+	hub.RegisterPatternHandler("orders.created.*", created)
+	hub.RegisterPatternHandler("*", single)
+	hub.RegisterCatchAllHandler(all)
+
+	for _, name := range []string{"orders", "orders.created.eu", "orders.created", "payments.received", "SomeEvent"} {
+		hub.Publish(event.WithName(name), nil).Wait()
+	}
+
+	hub.Publish(SomeOtherEvent{}, nil).Wait()
+
+	expectations := []struct {
+		handler  *recordingHandler
+		expected string
+	}{
+		{orders, "[orders orders.created orders.created.eu]"},
+		{created, "[orders.created.eu]"},
+		{single, "[SomeEvent SomeOtherEvent orders]"},
+		{all, "[SomeEvent SomeOtherEvent orders orders.created orders.created.eu payments.received]"},
+	}
+
+	for i, e := range expectations {
+		if got := fmt.Sprint(e.handler.received()); got != e.expected {
+			t.Errorf("Handler %d should have received %s, got %s", i, e.expected, got)
+		}
+	}
+
+	hub.UnregisterCatchAllHandler(all)
+	hub.Publish(event.WithName("orders"), nil).Wait()
+
+	if len(all.received()) != 6 {
+		t.Errorf("The catch-all handler should not have been called once unregistered")
+	}
+}
+
+func TestHub_PatternHandlers_Overlapping(t *testing.T) {
+	hub := NewEventHub(nil)
+	handler := &recordingHandler{}
+
+	hub.RegisterPatternHandler("**.eu", handler)
+	hub.RegisterHandler(event.WithName("orders.created.eu"), handler)
+
+	hub.Publish(event.WithName("orders.created.eu"), nil).Wait()
+	hub.Publish(event.WithName("eu"), nil).Wait()
+
+	if got := fmt.Sprint(handler.received()); got != "[eu orders.created.eu orders.created.eu]" {
+		t.Errorf("The handler should have been called once per registration, got %s", got)
+	}
+}
+
+func TestHub_PatternHandlers_Invalid(t *testing.T) {
+	hub := NewEventHub(nil)
+
+	for _, pattern := range []string{"", "orders..created", "orders.created*"} {
+		if err := hub.RegisterPatternHandler(pattern, &recordingHandler{}); !errors.Is(err, ErrInvalidPattern) {
+			t.Errorf("%q should have been rejected, got %v", pattern, err)
+		}
+	}
+}
+
+func BenchmarkHub_PatternMatching(b *testing.B) {
+	hub := NewEventHub(nil)
+
+	for i := 0; i < 10000; i++ {
+		hub.RegisterPatternHandler(fmt.Sprintf("tenant%d.orders.*", i), &recordingHandler{})
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		hub.mu.Lock()
+		hub.patternCallbacks("tenant42.orders.created")
+		hub.mu.Unlock()
+	}
+}
@@ -0,0 +1,107 @@
+package eventhub
+
+import (
+	"context"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy defines how many times, and how often, a failing handler is called again, with an exponential backoff.
+type RetryPolicy struct {
+	// MaxAttempts is the number of calls at most, the first one included, so 0 or 1 means no retry.
+	MaxAttempts int
+	// Backoff is the delay before the first retry.
+	Backoff time.Duration
+	// Multiplier grows the delay before every next retry, 2 when not set.
+	Multiplier float64
+	// MaxBackoff caps the delay before a retry, when set.
+	MaxBackoff time.Duration
+	// Jitter shortens every delay by a random fraction of it, up to the given one (between 0 and 1), so handlers
+	// failing together do not retry in lockstep.
+	Jitter float64
+}
+
+// delay returns the delay before the retry following an attempt.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+
+	d := float64(p.Backoff) * math.Pow(multiplier, float64(attempt-1))
+
+	if p.MaxBackoff > 0 && d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+
+	if p.Jitter > 0 {
+		d -= d * math.Min(p.Jitter, 1) * rand.Float64()
+	}
+
+	return time.Duration(d)
+}
+
+// deliver hands an event to the handler of a subscription, retrying as its policy says, and sends it to the dead
+// letter sink once the attempts are exhausted or the context is done. previous is the number of attempts already made,
+// when replaying a dead letter.
+func (h *EventHub) deliver(ctx context.Context, e event.Event, sub *subscription, previous int) error {
+	var err error
+
+	handler := h.handlerChain(sub)
+	attempts := 0
+
+	for {
+		attempts++
+
+		err = h.call(ctx, e, handler)
+		if err == nil {
+			return nil
+		}
+
+		if attempts >= sub.retry.MaxAttempts || !wait(ctx, sub.retry.delay(attempts)) {
+			break
+		}
+
+		h.l.Debug("Event handler failed, retrying", "event", event.GetName(e), "attempt", attempts, "reason", err)
+	}
+
+	h.l.Warn("Event handler failed", "reason", err)
+
+	if h.onHandlerError != nil {
+		h.onHandlerError(ctx, e, sub.handler, err)
+	}
+
+	if h.deadLetters != nil {
+		h.deadLetters.Put(DeadLetter{
+			Event:        e,
+			Handler:      sub.handler,
+			Subscription: sub.target,
+			Attempts:     previous + attempts,
+			Err:          err,
+			Time:         time.Now(),
+			sub:          sub,
+		})
+	}
+
+	return err
+}
+
+// wait waits for a delay, unless the context is done first.
+func wait(ctx context.Context, d time.Duration) bool {
+	if ctx == nil {
+		time.Sleep(d)
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
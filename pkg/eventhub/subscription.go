@@ -0,0 +1,36 @@
+package eventhub
+
+// SubscriptionOption customizes a subscription, see RegisterHandler, RegisterPatternHandler and Subscribe.
+type SubscriptionOption func(s *subscription)
+
+// subscription is a handler registered for an event name or a pattern.
+type subscription struct {
+	handler    Handler
+	target     string
+	retry      RetryPolicy
+	middleware []Middleware
+}
+
+func newSubscription(target string, handler Handler, options []SubscriptionOption) *subscription {
+	s := &subscription{handler: handler, target: target}
+
+	for _, option := range options {
+		option(s)
+	}
+
+	return s
+}
+
+// WithRetry makes the hub call the handler again when it fails, as the policy says.
+func WithRetry(policy RetryPolicy) SubscriptionOption {
+	return func(s *subscription) {
+		s.retry = policy
+	}
+}
+
+// WithMiddleware wraps the handler with middleware, inside the handler middleware of the hub, see UseHandler.
+func WithMiddleware(middleware ...Middleware) SubscriptionOption {
+	return func(s *subscription) {
+		s.middleware = append(s.middleware, middleware...)
+	}
+}
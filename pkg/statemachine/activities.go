@@ -0,0 +1,129 @@
+package statemachine
+
+import (
+	"context"
+	"github.com/a-inacio/edt-go/pkg/action"
+	"github.com/a-inacio/edt-go/pkg/event"
+)
+
+// ActivityEvent is triggered once the activity of a state returned, while the state is still active.
+// It carries the ActivityDone (or ActivityFailed) event of the state, under its name, so transitions are declared on
+// that event, and hooks and guards can get the outcome of the activity from the trigger.
+type ActivityEvent struct {
+	State  string
+	Event  event.Event
+	Result action.Result
+	Err    error
+	token  uint64
+}
+
+func (a *ActivityEvent) EventName() string {
+	return event.GetName(a.Event)
+}
+
+type runningActivity struct {
+	cancel context.CancelFunc
+	token  uint64
+}
+
+// startActivity runs the activity of a state being entered, in its own goroutine, with a context cancelled when the
+// state is left.
+func (sm *StateMachine) startActivity(node *Node) {
+	activity := node.State.Activity
+	if activity == nil {
+		return
+	}
+
+	ctx := sm.context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	sm.mu.Lock()
+	if running, ok := sm.activities[node]; ok {
+		running.cancel()
+	}
+
+	sm.activitySeq++
+	token := sm.activitySeq
+	sm.activities[node] = &runningActivity{cancel: cancel, token: token}
+	sm.mu.Unlock()
+
+	go func() {
+		res, err := activity(ctx)
+
+		// The state was left in the meantime
+		if ctx.Err() != nil {
+			return
+		}
+
+		e := node.State.ActivityDone
+		if err != nil {
+			e = node.State.ActivityFailed
+		}
+
+		if e == nil {
+			if err != nil {
+				sm.l.Warn("State activity failed", "state", node.State.Name, "reason", err)
+			}
+			return
+		}
+
+		if err := sm.TriggerEvent(&ActivityEvent{State: node.State.Name, Event: e, Result: res, Err: err, token: token}); err != nil {
+			sm.l.Warn("State activity event failed", "state", node.State.Name, "event", event.GetName(e), "reason", err)
+		}
+	}()
+}
+
+// syncActivities makes the running activities match the active states, after a transition, a failed one, a restore
+// or the completion of the state machine.
+func (sm *StateMachine) syncActivities() {
+	active := map[*Node]bool{}
+
+	sm.mu.Lock()
+	if !sm.completed {
+		for _, leaf := range sm.leaves {
+			for n := leaf; n != nil; n = n.Parent {
+				active[n] = true
+			}
+		}
+	}
+
+	for node, running := range sm.activities {
+		if !active[node] {
+			running.cancel()
+			delete(sm.activities, node)
+		}
+	}
+
+	var missing []*Node
+	for n := range active {
+		if _, ok := sm.activities[n]; !ok && n.State.Activity != nil {
+			missing = append(missing, n)
+		}
+	}
+	sm.mu.Unlock()
+
+	for _, n := range missing {
+		sm.startActivity(n)
+	}
+}
+
+// isCurrentActivity tells if the activity an event comes from is still running, it may have been restarted or its
+// state left while the event was queued.
+func (sm *StateMachine) isCurrentActivity(a *ActivityEvent) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	running, ok := sm.activities[sm.nodes[a.State]]
+	if !ok || running.token != a.token {
+		return false
+	}
+
+	running.cancel()
+	delete(sm.activities, sm.nodes[a.State])
+
+	return true
+}
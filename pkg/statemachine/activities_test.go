@@ -0,0 +1,156 @@
+package statemachine
+
+import (
+	"context"
+	"errors"
+	"github.com/a-inacio/edt-go/pkg/action"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStateMachine_Activity_Done(t *testing.T) {
+	downloaded := make(chan action.Result, 1)
+
+	sm, _ := NewStateMachine(&State{
+		Name: "Downloading",
+		Activity: func(ctx context.Context) (action.Result, error) {
+			return "file.zip", nil
+		},
+		ActivityDone:   event.WithName("Downloaded"),
+		ActivityFailed: event.WithName("DownloadFailed"),
+	}, context.Background())
+
+	sm.AddState(&State{Name: "Ready", OnEnter: func(ctx context.Context, trigger Trigger) error {
+		downloaded <- (*trigger.Event).(*ActivityEvent).Result
+		return nil
+	}})
+	sm.AddState(&State{Name: "Failed"})
+	sm.AddTransition("Downloading", event.WithName("Downloaded"), "Ready")
+	sm.AddTransition("Downloading", event.WithName("DownloadFailed"), "Failed")
+
+	sm.Start()
+
+	select {
+	case res := <-downloaded:
+		if res != "file.zip" {
+			t.Errorf("Ready State, should have received the result of the activity, got %v", res)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Ready State, should have been entered once the activity completed, got %s", sm.CurrentState())
+	}
+}
+
+func TestStateMachine_Activity_Failed(t *testing.T) {
+	failed := make(chan error, 1)
+
+	sm, _ := NewStateMachine(&State{
+		Name: "Downloading",
+		Activity: func(ctx context.Context) (action.Result, error) {
+			return nil, errors.New("connection lost")
+		},
+		ActivityFailed: event.WithName("DownloadFailed"),
+	}, context.Background())
+
+	sm.AddState(&State{Name: "Failed", OnEnter: func(ctx context.Context, trigger Trigger) error {
+		failed <- (*trigger.Event).(*ActivityEvent).Err
+		return nil
+	}})
+	sm.AddTransition("Downloading", event.WithName("DownloadFailed"), "Failed")
+
+	sm.Start()
+
+	select {
+	case err := <-failed:
+		if err == nil || err.Error() != "connection lost" {
+			t.Errorf("Failed State, should have received the failure of the activity, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Failed State, should have been entered once the activity failed, got %s", sm.CurrentState())
+	}
+}
+
+func TestStateMachine_Activity_CancelledOnExit(t *testing.T) {
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+
+	sm, _ := NewStateMachine(&State{
+		Name: "Polling",
+		Activity: func(ctx context.Context) (action.Result, error) {
+			close(started)
+			<-ctx.Done()
+			close(cancelled)
+			return nil, ctx.Err()
+		},
+		ActivityDone: event.WithName("Polled"),
+	}, context.Background())
+
+	sm.AddState(&State{Name: "Stopped"})
+	sm.AddState(&State{Name: "Done"})
+	sm.AddTransition("Polling", event.WithName("Stop"), "Stopped")
+	sm.AddTransition("Polling", event.WithName("Polled"), "Done")
+
+	sm.Start()
+	<-started
+
+	sm.TriggerEvent(event.WithName("Stop"))
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("The activity should have been cancelled once Polling State was left")
+	}
+
+	if sm.CurrentState() != "Stopped" {
+		t.Errorf("Stopped State, should be active, got %s", sm.CurrentState())
+	}
+}
+
+func TestStateMachine_Activity_KeptOnFailedTransition(t *testing.T) {
+	var started int32
+	release := make(chan struct{})
+
+	sm, _ := NewStateMachine(&State{
+		Name: "Polling",
+		Activity: func(ctx context.Context) (action.Result, error) {
+			atomic.AddInt32(&started, 1)
+
+			select {
+			case <-release:
+				return nil, nil
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		},
+		ActivityDone: event.WithName("Polled"),
+	}, context.Background())
+
+	sm.AddState(&State{Name: "Stopped"})
+	sm.AddState(&State{Name: "Done"})
+	sm.AddTransition("Polling", event.WithName("Stop"), "Stopped", WithAction(func(ctx context.Context) (action.Result, error) {
+		return nil, errors.New("cannot stop")
+	}))
+	sm.AddTransition("Polling", event.WithName("Polled"), "Done")
+
+	sm.Start()
+
+	if err := sm.TriggerEvent(event.WithName("Stop")); err == nil {
+		t.Fatal("Stop should have failed")
+	}
+
+	close(release)
+
+	deadline := time.Now().Add(time.Second)
+	for sm.CurrentState() != "Done" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if sm.CurrentState() != "Done" {
+		t.Errorf("Polling State, its activity should have kept running after the failed transition, got %s", sm.CurrentState())
+	}
+
+	if n := atomic.LoadInt32(&started); n != 1 {
+		t.Errorf("Polling State, its activity should have been started once, got %d", n)
+	}
+}
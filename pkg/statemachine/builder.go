@@ -4,15 +4,27 @@ import (
 	"context"
 	"fmt"
 	"github.com/a-inacio/edt-go/internal/mermaid"
+	"github.com/a-inacio/edt-go/pkg/action"
 	"github.com/a-inacio/edt-go/pkg/event"
 	"github.com/a-inacio/edt-go/pkg/eventhub"
+	"github.com/a-inacio/rosetta-logger-go/pkg/logger"
+	"sort"
 	"strings"
+	"time"
 )
 
 type transitionBuilder struct {
-	from  string
-	to    string
-	event event.Event
+	from     string
+	to       string
+	event    event.Event
+	opts     []TransitionOption
+	internal bool
+}
+
+type stateBuilder struct {
+	state  *State
+	parent string
+	region int
 }
 
 type eventBuilder struct {
@@ -23,16 +35,62 @@ type eventBuilder struct {
 
 type StateMachineBuilder struct {
 	initialState *State
-	states       []*State
+	states       []stateBuilder
+	initials     map[string]string
 	transitions  []transitionBuilder
 	graph        string
 	events       []eventBuilder
 	context      context.Context
 	hub          *eventhub.EventHub
+	guards       map[string]Guard
+	actions      map[string]action.Action
+	errorState   string
+	logger       logger.Logger
+	queueSize    int
+	queuePolicy  QueuePolicy
+	onComplete   func(ctx context.Context, trigger Trigger) (action.Result, error)
+	validate     bool
+	id           string
+	version      string
+	store        Store
+	historySize  *int
+	histories    []historyBuilder
+	timed        []timedTransitionBuilder
+	clock        Clock
+	unhandled    UnhandledPolicy
+	policies     []statePolicyBuilder
+	fallback     FallbackHandler
+	hooks        map[string]Hook
+	schema       *schema
+	schemaErr    error
+	subFinals    []schemaLocation
+}
+
+type statePolicyBuilder struct {
+	state  string
+	policy UnhandledPolicy
+}
+
+type timedTransitionBuilder struct {
+	from  string
+	to    string
+	after time.Duration
+	opts  []TransitionOption
+}
+
+type historyBuilder struct {
+	parent      string
+	name        string
+	historyType HistoryType
 }
 
 func NewBuilder() *StateMachineBuilder {
-	return &StateMachineBuilder{}
+	return &StateMachineBuilder{
+		initials: map[string]string{},
+		guards:   map[string]Guard{},
+		actions:  map[string]action.Action{},
+		hooks:    map[string]Hook{},
+	}
 }
 
 func (builder *StateMachineBuilder) WithInitialState(initialState *State) *StateMachineBuilder {
@@ -86,60 +144,351 @@ func (builder *StateMachineBuilder) FromGraph(graph string) *StateMachineBuilder
 }
 
 func (builder *StateMachineBuilder) AddState(state *State) *StateMachineBuilder {
-	builder.states = append(builder.states, state)
+	builder.states = append(builder.states, stateBuilder{state: state})
 	return builder
 }
 
-func (builder *StateMachineBuilder) AddTransition(from string, event event.Event, to string) *StateMachineBuilder {
+// AddSubState adds a state nested inside a composite state.
+// States declared inside a `state X { ... }` block of the graph do not need to be added this way.
+func (builder *StateMachineBuilder) AddSubState(parent string, state *State) *StateMachineBuilder {
+	builder.states = append(builder.states, stateBuilder{state: state, parent: parent})
+	return builder
+}
+
+// AddSubStateInRegion adds a state nested inside a concurrent region of a composite state, regions being numbered
+// from zero. States declared inside a `state X { ... }` block of the graph, where regions are separated by `--`, do not
+// need to be added this way.
+func (builder *StateMachineBuilder) AddSubStateInRegion(parent string, region int, state *State) *StateMachineBuilder {
+	builder.states = append(builder.states, stateBuilder{state: state, parent: parent, region: region})
+	return builder
+}
+
+// WithInitialSubState defines which sub-state is entered when the composite state is entered.
+// Without it, the first sub-state added (or the `[*] --> X` of the graph block) is used.
+func (builder *StateMachineBuilder) WithInitialSubState(parent string, state string) *StateMachineBuilder {
+	builder.initials[parent] = state
+	return builder
+}
+
+// AddHistoryState adds a history pseudo-state to a composite state, a transition targeting it resumes the composite
+// state where it was last left.
+// In the graph, it is declared inside the composite state block: `state H <<history>>` or `state H <<deepHistory>>`.
+func (builder *StateMachineBuilder) AddHistoryState(parent string, name string, historyType HistoryType) *StateMachineBuilder {
+	builder.histories = append(builder.histories, historyBuilder{parent: parent, name: name, historyType: historyType})
+	return builder
+}
+
+func (builder *StateMachineBuilder) AddTransition(from string, event event.Event, to string, opts ...TransitionOption) *StateMachineBuilder {
 	builder.transitions = append(builder.transitions, transitionBuilder{
 		from:  from,
 		event: event,
 		to:    to,
+		opts:  opts,
+	})
+	return builder
+}
+
+// AddInternalTransition adds a transition that runs its action without leaving the state, see
+// StateMachine.AddInternalTransition.
+// In the graph, it is declared as a self-transition with an `internal` label, e.g.
+// `Retrying --> Retrying : internal Tick / count`, while `external` (the default) makes an explicit external
+// self-transition.
+func (builder *StateMachineBuilder) AddInternalTransition(state string, event event.Event, opts ...TransitionOption) *StateMachineBuilder {
+	builder.transitions = append(builder.transitions, transitionBuilder{
+		from:     state,
+		event:    event,
+		to:       state,
+		opts:     opts,
+		internal: true,
+	})
+	return builder
+}
+
+// AddCompletionTransition adds a transition taken as soon as the source state is entered, see
+// StateMachine.AddCompletionTransition.
+func (builder *StateMachineBuilder) AddCompletionTransition(from string, to string, opts ...TransitionOption) *StateMachineBuilder {
+	builder.transitions = append(builder.transitions, transitionBuilder{
+		from: from,
+		to:   to,
+		opts: opts,
+	})
+	return builder
+}
+
+// AddTimedTransition adds a transition taken once the source state was active for the given duration.
+// In the graph, it is declared with an `after` label, e.g. `Connecting --> Failed : after 10s`.
+func (builder *StateMachineBuilder) AddTimedTransition(from string, after time.Duration, to string, opts ...TransitionOption) *StateMachineBuilder {
+	builder.timed = append(builder.timed, timedTransitionBuilder{
+		from:  from,
+		after: after,
+		to:    to,
+		opts:  opts,
 	})
 	return builder
 }
 
+// WithClock replaces the clock driving timed transitions, SystemClock by default.
+func (builder *StateMachineBuilder) WithClock(clock Clock) *StateMachineBuilder {
+	builder.clock = clock
+	return builder
+}
+
+func (builder *StateMachineBuilder) WithLogger(l logger.Logger) *StateMachineBuilder {
+	builder.logger = l
+	return builder
+}
+
+// WithHistorySize bounds the number of transitions kept in the history log, DefaultHistorySize by default.
+func (builder *StateMachineBuilder) WithHistorySize(size int) *StateMachineBuilder {
+	builder.historySize = &size
+	return builder
+}
+
+// WithQueue bounds the number of pending events, by default the queue is unbounded.
+func (builder *StateMachineBuilder) WithQueue(size int, policy QueuePolicy) *StateMachineBuilder {
+	builder.queueSize = size
+	builder.queuePolicy = policy
+	return builder
+}
+
+// OnComplete defines a hook called once the final state is entered, its outcome becomes the state machine result.
+func (builder *StateMachineBuilder) OnComplete(onComplete func(ctx context.Context, trigger Trigger) (action.Result, error)) *StateMachineBuilder {
+	builder.onComplete = onComplete
+	return builder
+}
+
+// WithErrorState defines the state entered when a transition fails.
+// Without it, a failing transition is aborted and the state machine stays in the state it was.
+func (builder *StateMachineBuilder) WithErrorState(state string) *StateMachineBuilder {
+	builder.errorState = state
+	return builder
+}
+
+// RegisterAction makes an action available to the graph, where it is referenced by name on a transition label,
+// after a slash, e.g. `A --> B: / notify`.
+func (builder *StateMachineBuilder) RegisterAction(name string, a action.Action) *StateMachineBuilder {
+	builder.actions[name] = a
+	return builder
+}
+
+// RegisterGuard makes a guard available to the graph, where it is referenced by name on a transition label,
+// e.g. `A --> B: [isValid]`.
+func (builder *StateMachineBuilder) RegisterGuard(name string, guard Guard) *StateMachineBuilder {
+	builder.guards[name] = guard
+	return builder
+}
+
+// WithValidation makes Build validate the definition, see StateMachine.Validate. Instead of failing on the first
+// problem, Build then returns a *ValidationError with all findings, including events declared with WithEvents,
+// WithEventNames or WithEventForEntering that no transition uses and states referenced in the graph that were never
+// added.
+func (builder *StateMachineBuilder) WithValidation() *StateMachineBuilder {
+	builder.validate = true
+	return builder
+}
+
+// WithID defines the identifier of the state machine instance, used as key when persisting it.
+func (builder *StateMachineBuilder) WithID(id string) *StateMachineBuilder {
+	builder.id = id
+	return builder
+}
+
+// WithVersion defines the version of the state machine definition, snapshots can only be restored by the same version.
+func (builder *StateMachineBuilder) WithVersion(version string) *StateMachineBuilder {
+	builder.version = version
+	return builder
+}
+
+// WithStore makes the state machine save a snapshot in the store every time its state changes.
+func (builder *StateMachineBuilder) WithStore(store Store) *StateMachineBuilder {
+	builder.store = store
+	return builder
+}
+
+// WithUnhandledPolicy defines what happens to the events no active state has a transition for, see UnhandledPolicy.
+func (builder *StateMachineBuilder) WithUnhandledPolicy(policy UnhandledPolicy) *StateMachineBuilder {
+	builder.unhandled = policy
+	return builder
+}
+
+// WithStateUnhandledPolicy defines the unhandled policy while the state, or any state nested inside it, is active.
+func (builder *StateMachineBuilder) WithStateUnhandledPolicy(state string, policy UnhandledPolicy) *StateMachineBuilder {
+	builder.policies = append(builder.policies, statePolicyBuilder{state: state, policy: policy})
+	return builder
+}
+
+// WithFallbackHandler defines the handler of the events routed by the UnhandledFallback policy.
+func (builder *StateMachineBuilder) WithFallbackHandler(handler FallbackHandler) *StateMachineBuilder {
+	builder.fallback = handler
+	return builder
+}
+
 func (builder *StateMachineBuilder) Build() (*StateMachine, error) {
+	if builder.schema != nil || builder.schemaErr != nil {
+		b, err := builder.withSchema()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return b.Build()
+	}
+
 	stateMachine, err := NewStateMachine(builder.initialState, builder.context)
 
 	if err != nil {
 		return nil, err
 	}
 
-	for _, s := range builder.states {
-		err = stateMachine.AddState(s)
+	graph := &mermaid.Graph{Parents: map[string]string{}}
+
+	if builder.graph != "" {
+		graph, err = mermaid.ParseGraph(builder.graph)
 
 		if err != nil {
 			return nil, err
 		}
+
+		if err = checkPseudoStates(graph); err != nil {
+			return nil, err
+		}
 	}
 
-	if builder.graph != "" {
-		mNodes, err := mermaid.Parse(builder.graph)
+	err = builder.addStates(stateMachine, graph)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = builder.addHistoryStates(stateMachine, graph)
+
+	if err != nil {
+		return nil, err
+	}
+
+	findings := &buildFindings{collect: builder.validate}
+
+	if builder.validate {
+		for _, name := range graph.States {
+			if _, ok := stateMachine.nodes[name]; !ok {
+				findings.add(fmt.Errorf("%w: %s is referenced in the graph but was not added", ErrUnknownState, name))
+			}
+		}
+	}
+
+	for _, node := range graph.Transitions {
+		if node.SourceIsInitial && node.Parent != "" {
+			if _, explicit := builder.initials[node.Parent]; explicit {
+				continue
+			}
+
+			err = findings.add(stateMachine.SetInitialSubState(node.Parent, node.To))
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for parent, state := range builder.initials {
+		err = findings.add(stateMachine.SetInitialSubState(parent, state))
 
 		if err != nil {
 			return nil, err
 		}
+	}
 
-		refTable, err := builder.eventReferenceTable()
+	// The events to subscribe to on the hub, once built
+	var hubEvents []event.Event
+
+	if builder.graph != "" {
+		byState, byName, err := builder.eventReferenceTable()
 
 		if err != nil {
 			return nil, err
 		}
 
-		for _, node := range mNodes {
-			if node.SourceIsInitial || node.TargetIsTerminal {
+		for _, node := range graph.Transitions {
+			if node.SourceIsInitial {
+				continue
+			}
+
+			// Already reported as missing
+			if builder.validate && (stateMachine.nodes[node.From] == nil || stateMachine.nodes[node.To] == nil) {
+				continue
+			}
+
+			// Inside a composite state, [*] is the final state of the region
+			to := node.To
+			if node.TargetIsTerminal && node.Parent != "" {
+				to, err = stateMachine.AddSubFinalState(node.Parent, graph.Regions[node.From])
+
+				if err != nil {
+					return nil, fmt.Errorf("line %d, %s --> %s: %w", node.Line, node.From, node.To, err)
+				}
+			}
+
+			label, err := builder.parseLabel(node.Label)
+
+			if err != nil {
+				return nil, fmt.Errorf("line %d, %s --> %s: %w", node.Line, node.From, node.To, err)
+			}
+
+			if label.internal && (node.From != node.To || label.event == "" || label.after > 0) {
+				return nil, fmt.Errorf("line %d, %s --> %s: an internal transition must be a self-transition on an event", node.Line, node.From, node.To)
+			}
+
+			if label.after > 0 {
+				err = findings.add(stateMachine.AddTimedTransition(node.From, label.after, to, label.opts...))
+
+				if err != nil {
+					return nil, err
+				}
+
+				continue
+			}
+
+			// The label names the event, otherwise the event for entering the target state is used
+			var e event.Event
+			var ok bool
+
+			if label.event != "" {
+				e, ok = byName[label.event]
+				if !ok {
+					e, ok = event.WithName(label.event), true
+				}
+			} else {
+				e, ok = byState[node.To]
+			}
+
+			source, exists := stateMachine.nodes[node.From]
+			if !exists {
+				return nil, fmt.Errorf("%w: unknown source state %s", ErrUnknownState, node.From)
+			}
+
+			if !ok && (node.TargetIsTerminal || source.IsComposite()) {
+				// Without an event, reaching the final state happens as soon as the source state is entered, and
+				// leaving a composite state as soon as its regions reached their final states
+				err = findings.add(stateMachine.AddCompletionTransition(node.From, to, label.opts...))
+
+				if err != nil {
+					return nil, err
+				}
+
 				continue
 			}
 
-			e, ok := refTable[node.To]
 			if !ok {
 				return nil, fmt.Errorf("no transition event defined for %s --> %s", node.From, node.To)
 			}
 
-			err = stateMachine.AddTransition(node.From, e, node.To)
+			if label.internal {
+				err = findings.add(stateMachine.AddInternalTransition(node.From, e, label.opts...))
+			} else {
+				err = findings.add(stateMachine.AddTransition(node.From, e, to, label.opts...))
+			}
 
-			builder.trySubscribeFromHub(e, stateMachine)
+			hubEvents = append(hubEvents, e)
 
 			if err != nil {
 				return nil, err
@@ -147,21 +496,193 @@ func (builder *StateMachineBuilder) Build() (*StateMachine, error) {
 		}
 	}
 
+	for _, f := range builder.subFinals {
+		_, err = stateMachine.AddSubFinalState(f.parent, f.region)
+
+		if err = findings.add(err); err != nil {
+			return nil, err
+		}
+	}
+
 	for _, t := range builder.transitions {
-		err = stateMachine.AddTransition(t.from, t.event, t.to)
+		if t.event == nil {
+			if err = findings.add(stateMachine.AddCompletionTransition(t.from, t.to, t.opts...)); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		if t.internal {
+			err = findings.add(stateMachine.AddInternalTransition(t.from, t.event, t.opts...))
+		} else {
+			err = findings.add(stateMachine.AddTransition(t.from, t.event, t.to, t.opts...))
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		hubEvents = append(hubEvents, t.event)
+	}
+
+	for _, t := range builder.timed {
+		err = findings.add(stateMachine.AddTimedTransition(t.from, t.after, t.to, t.opts...))
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if builder.errorState != "" {
+		err = findings.add(stateMachine.SetErrorState(builder.errorState))
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, p := range builder.policies {
+		err = findings.add(stateMachine.SetStateUnhandledPolicy(p.state, p.policy))
 
 		if err != nil {
 			return nil, err
 		}
+	}
+
+	if builder.validate {
+		findings.errs = append(findings.errs, builder.unusedEvents(stateMachine)...)
+		findings.errs = append(findings.errs, stateMachine.validate()...)
+
+		if len(findings.errs) > 0 {
+			return nil, &ValidationError{Findings: findings.errs}
+		}
+	}
+
+	stateMachine.hub = builder.hub
+	stateMachine.SetOnComplete(builder.onComplete)
+	stateMachine.SetQueue(builder.queueSize, builder.queuePolicy)
+	stateMachine.SetID(builder.id)
+	stateMachine.SetVersion(builder.version)
+	stateMachine.SetStore(builder.store)
+	stateMachine.SetUnhandledPolicy(builder.unhandled)
+	stateMachine.SetFallbackHandler(builder.fallback)
+
+	if builder.clock != nil {
+		stateMachine.SetClock(builder.clock)
+	}
 
-		builder.trySubscribeFromHub(t.event, stateMachine)
+	if builder.historySize != nil {
+		stateMachine.SetHistorySize(*builder.historySize)
+	}
+
+	if builder.logger != nil {
+		stateMachine.l = builder.logger
+	}
+
+	// Subscribing last, so a failed build leaves no handler on the hub
+	for _, e := range hubEvents {
+		builder.trySubscribeFromHub(e, stateMachine)
 	}
 
 	return stateMachine, err
 }
 
-func (builder *StateMachineBuilder) eventReferenceTable() (map[string]event.Event, error) {
-	table := map[string]event.Event{}
+// addStates adds the states making sure composite states are added before their sub-states, and regions in order.
+// The parent of a state is the explicit one from AddSubState, otherwise the enclosing block of the graph.
+func (builder *StateMachineBuilder) addStates(sm *StateMachine, graph *mermaid.Graph) error {
+	pending := make([]stateBuilder, 0, len(builder.states))
+
+	for _, s := range builder.states {
+		if s.parent == "" {
+			s.parent = graph.Parents[s.state.Name]
+			s.region = graph.Regions[s.state.Name]
+		}
+
+		pending = append(pending, s)
+	}
+
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].region < pending[j].region
+	})
+
+	for len(pending) > 0 {
+		var deferred []stateBuilder
+
+		for _, s := range pending {
+			var err error
+
+			if s.parent == "" {
+				err = sm.AddState(s.state)
+			} else if _, ok := sm.nodes[s.parent]; ok {
+				err = sm.AddSubStateInRegion(s.parent, s.region, s.state)
+			} else {
+				deferred = append(deferred, s)
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(deferred) == len(pending) {
+			return fmt.Errorf("unknown parent state %s for %s", deferred[0].parent, deferred[0].state.Name)
+		}
+
+		pending = deferred
+	}
+
+	return nil
+}
+
+// checkPseudoStates rejects the pseudo-states of the graph that have no semantics in the state machine, rather than
+// failing later on a transition to an unknown state.
+func checkPseudoStates(graph *mermaid.Graph) error {
+	for _, name := range graph.States {
+		switch kind := graph.Kinds[name]; kind {
+		case "choice", "fork", "join":
+			return fmt.Errorf("%w: %s <<%s>>", ErrUnsupportedPseudoState, name, kind)
+		}
+	}
+
+	return nil
+}
+
+// addHistoryStates adds the history pseudo-states, the ones declared in the graph with a history stereotype and the
+// ones from AddHistoryState.
+func (builder *StateMachineBuilder) addHistoryStates(sm *StateMachine, graph *mermaid.Graph) error {
+	histories := builder.histories
+
+	for _, name := range graph.States {
+		for historyType, stereotype := range historyStereotypes {
+			if graph.Kinds[name] != stereotype {
+				continue
+			}
+
+			if graph.Parents[name] == "" {
+				return fmt.Errorf("history state %s must be declared inside a composite state", name)
+			}
+
+			histories = append(histories, historyBuilder{parent: graph.Parents[name], name: name, historyType: historyType})
+		}
+	}
+
+	for _, h := range histories {
+		if err := sm.AddHistoryState(h.parent, h.name, h.historyType); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// eventReferenceTable indexes the declared events by the state they lead to and by name.
+// Events not bound to a state with WithEventForEntering lead, by convention, to the state named after them when their
+// name starts with GoTo (e.g. GoToB leads to B).
+func (builder *StateMachineBuilder) eventReferenceTable() (map[string]event.Event, map[string]event.Event, error) {
+	byState := map[string]event.Event{}
+	byName := map[string]event.Event{}
 
 	for _, e := range builder.events {
 		state := e.state
@@ -176,23 +697,147 @@ func (builder *StateMachineBuilder) eventReferenceTable() (map[string]event.Even
 			eventInstance = event.WithName(eventName)
 		}
 
-		if state == "" {
+		byName[eventName] = eventInstance
 
+		if state == "" {
 			if !strings.HasPrefix(eventName, "GoTo") {
-				return nil, fmt.Errorf("a conventional event must have a name starting by GoTo, got: %s", eventName)
+				continue
 			}
 
 			state = strings.TrimPrefix(eventName, "GoTo")
 		}
 
-		if _, alreadyAdded := table[state]; alreadyAdded {
-			return nil, fmt.Errorf("state %s already has a transition event %s", state, eventName)
+		if _, alreadyAdded := byState[state]; alreadyAdded {
+			return nil, nil, fmt.Errorf("state %s already has a transition event %s", state, eventName)
+		}
+
+		byState[state] = eventInstance
+	}
+
+	return byState, byName, nil
+}
+
+type transitionLabel struct {
+	event string
+	// internal is set by the `internal` keyword, see AddInternalTransition.
+	internal bool
+	// after is the duration of a timed transition, `after 10s`.
+	after time.Duration
+	opts  []TransitionOption
+}
+
+// Keywords of transition labels, telling the kind of the transition.
+const (
+	internalKeyword = "internal"
+	externalKeyword = "external"
+)
+
+// parseLabel parses a graph transition label, `Event [guard] / action`, where all parts are optional.
+// The event can also be the delay of a timed transition: `after 10s [guard] / action`, and be preceded by the kind of
+// the transition: `internal Event` or `external Event`.
+// The guard and the action must have been registered on the builder.
+func (builder *StateMachineBuilder) parseLabel(text string) (*transitionLabel, error) {
+	label := &transitionLabel{}
+
+	if idx := strings.Index(text, "/"); idx >= 0 {
+		name := strings.TrimSpace(text[idx+1:])
+		text = text[:idx]
+
+		a, ok := builder.actions[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown action: %s", name)
 		}
 
-		table[state] = eventInstance
+		label.opts = append(label.opts, WithNamedAction(name, a))
+	}
+
+	if start := strings.Index(text, "["); start >= 0 {
+		end := strings.Index(text[start:], "]")
+		if end < 0 {
+			return nil, fmt.Errorf("guard not closed: %s", text)
+		}
+
+		name := strings.TrimSpace(text[start+1 : start+end])
+
+		guard, ok := builder.guards[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown guard: %s", name)
+		}
+
+		label.opts = append(label.opts, WithNamedGuard(name, guard))
+		text = text[:start] + text[start+end+1:]
+	}
+
+	label.event = strings.TrimSpace(text)
+
+	if kind, rest, found := strings.Cut(label.event, " "); found && (kind == internalKeyword || kind == externalKeyword) {
+		label.internal = kind == internalKeyword
+		label.event = strings.TrimSpace(rest)
+	}
+
+	after, timed, err := parseTimedEventName(label.event)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if timed {
+		label.event = timedEventName(after)
+		label.after = after
+		return label, nil
+	}
+
+	if strings.ContainsAny(label.event, " \t") {
+		return nil, fmt.Errorf("invalid event name: %s", label.event)
+	}
+
+	return label, nil
+}
+
+// unusedEvents reports the declared events that no transition uses.
+func (builder *StateMachineBuilder) unusedEvents(sm *StateMachine) []error {
+	used := map[string]bool{}
+
+	for _, node := range sm.nodes {
+		for eventName := range node.Transitions {
+			used[eventName] = true
+		}
+	}
+
+	var findings []error
+	reported := map[string]bool{}
+
+	for _, e := range builder.events {
+		name := e.name
+		if name == "" {
+			name = event.GetName(e.event)
+		}
+
+		if used[name] || reported[name] {
+			continue
+		}
+
+		reported[name] = true
+		findings = append(findings, fmt.Errorf("%w: %s", ErrUnusedEvent, name))
+	}
+
+	return findings
+}
+
+// buildFindings decides, while building, whether an error stops the build or is collected for validation.
+type buildFindings struct {
+	collect bool
+	errs    []error
+}
+
+// add returns the error when it should stop the build, nil when it was collected (or there was none).
+func (f *buildFindings) add(err error) error {
+	if err == nil || !f.collect {
+		return err
 	}
 
-	return table, nil
+	f.errs = append(f.errs, err)
+	return nil
 }
 
 func (builder *StateMachineBuilder) trySubscribeFromHub(e event.Event, sm *StateMachine) {
@@ -200,5 +845,5 @@ func (builder *StateMachineBuilder) trySubscribeFromHub(e event.Event, sm *State
 		return
 	}
 
-	builder.hub.RegisterHandler(e, &stateMachineHubHandler{sm: sm})
+	sm.subscribeFrom(builder.hub, e)
 }
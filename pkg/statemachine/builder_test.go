@@ -2,9 +2,15 @@ package statemachine
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"github.com/a-inacio/edt-go/internal/mermaid"
+	"github.com/a-inacio/edt-go/pkg/action"
 	"github.com/a-inacio/edt-go/pkg/event"
 	"github.com/a-inacio/edt-go/pkg/eventhub"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewStateMachine_FromBuilder(t *testing.T) {
@@ -14,11 +20,13 @@ func TestNewStateMachine_FromBuilder(t *testing.T) {
 	sm, err := NewBuilder().
 		WithInitialState(&State{
 			Name: "Initial",
-			OnBefore: func(ctx context.Context, trigger Trigger) {
+			OnBefore: func(ctx context.Context, trigger Trigger) error {
 				onBeforeCalled = true
+				return nil
 			},
-			OnEnter: func(ctx context.Context, trigger Trigger) {
+			OnEnter: func(ctx context.Context, trigger Trigger) error {
 				onEnterCalled = true
+				return nil
 			},
 		}).
 		WithContext(context.Background()).
@@ -56,18 +64,21 @@ func TestStateMachine_TriggerEvent_FromBuilder(t *testing.T) {
 	sm, _ := NewBuilder().
 		WithInitialState(&State{
 			Name: "A",
-			OnAfter: func(ctx context.Context, trigger Trigger) {
+			OnAfter: func(ctx context.Context, trigger Trigger) error {
 				onAfterCalledA = true
+				return nil
 			},
 		}).
 		WithContext(context.Background()).
 		AddState(&State{
 			Name: "B",
-			OnBefore: func(ctx context.Context, trigger Trigger) {
+			OnBefore: func(ctx context.Context, trigger Trigger) error {
 				onBeforeCalledB = true
+				return nil
 			},
-			OnEnter: func(ctx context.Context, trigger Trigger) {
+			OnEnter: func(ctx context.Context, trigger Trigger) error {
 				onEnterCalledB = true
+				return nil
 			},
 		}).
 		AddState(&State{
@@ -129,18 +140,21 @@ func TestStateMachine_TriggerEvent_FromBuilder_WithGraph(t *testing.T) {
 	sm, _ := NewBuilder().
 		WithInitialState(&State{
 			Name: "A",
-			OnAfter: func(ctx context.Context, trigger Trigger) {
+			OnAfter: func(ctx context.Context, trigger Trigger) error {
 				onAfterCalledA = true
+				return nil
 			},
 		}).
 		WithContext(context.Background()).
 		AddState(&State{
 			Name: "B",
-			OnBefore: func(ctx context.Context, trigger Trigger) {
+			OnBefore: func(ctx context.Context, trigger Trigger) error {
 				onBeforeCalledB = true
+				return nil
 			},
-			OnEnter: func(ctx context.Context, trigger Trigger) {
+			OnEnter: func(ctx context.Context, trigger Trigger) error {
 				onEnterCalledB = true
+				return nil
 			},
 		}).
 		AddState(&State{
@@ -198,18 +212,21 @@ func TestStateMachine_TriggerEvent_FromBuilder_WithGenericEvents(t *testing.T) {
 	sm, _ := NewBuilder().
 		WithInitialState(&State{
 			Name: "A",
-			OnAfter: func(ctx context.Context, trigger Trigger) {
+			OnAfter: func(ctx context.Context, trigger Trigger) error {
 				onAfterCalledA = true
+				return nil
 			},
 		}).
 		WithContext(context.Background()).
 		AddState(&State{
 			Name: "B",
-			OnBefore: func(ctx context.Context, trigger Trigger) {
+			OnBefore: func(ctx context.Context, trigger Trigger) error {
 				onBeforeCalledB = true
+				return nil
 			},
-			OnEnter: func(ctx context.Context, trigger Trigger) {
+			OnEnter: func(ctx context.Context, trigger Trigger) error {
 				onEnterCalledB = true
+				return nil
 			},
 		}).
 		AddState(&State{
@@ -262,18 +279,21 @@ func TestStateMachine_TriggerEvent_FromBuilder_WithGraph_WithGenericEvents(t *te
 	sm, _ := NewBuilder().
 		WithInitialState(&State{
 			Name: "A",
-			OnAfter: func(ctx context.Context, trigger Trigger) {
+			OnAfter: func(ctx context.Context, trigger Trigger) error {
 				onAfterCalledA = true
+				return nil
 			},
 		}).
 		WithContext(context.Background()).
 		AddState(&State{
 			Name: "B",
-			OnBefore: func(ctx context.Context, trigger Trigger) {
+			OnBefore: func(ctx context.Context, trigger Trigger) error {
 				onBeforeCalledB = true
+				return nil
 			},
-			OnEnter: func(ctx context.Context, trigger Trigger) {
+			OnEnter: func(ctx context.Context, trigger Trigger) error {
 				onEnterCalledB = true
+				return nil
 			},
 		}).
 		AddState(&State{
@@ -342,18 +362,21 @@ func TestStateMachine_TriggerEvent_FromBuilder_WithHub(t *testing.T) {
 	sm, _ := NewBuilder().
 		WithInitialState(&State{
 			Name: "A",
-			OnAfter: func(ctx context.Context, trigger Trigger) {
+			OnAfter: func(ctx context.Context, trigger Trigger) error {
 				onAfterCalledA = true
+				return nil
 			},
 		}).
 		WithContext(context.Background()).
 		AddState(&State{
 			Name: "B",
-			OnBefore: func(ctx context.Context, trigger Trigger) {
+			OnBefore: func(ctx context.Context, trigger Trigger) error {
 				onBeforeCalledB = true
+				return nil
 			},
-			OnEnter: func(ctx context.Context, trigger Trigger) {
+			OnEnter: func(ctx context.Context, trigger Trigger) error {
 				onEnterCalledB = true
+				return nil
 			},
 		}).
 		AddState(&State{
@@ -383,3 +406,499 @@ func TestStateMachine_TriggerEvent_FromBuilder_WithHub(t *testing.T) {
 		t.Error("B State, OnEnter should have been called")
 	}
 }
+
+func TestStateMachine_TriggerEvent_FromBuilder_WithCompositeGraph(t *testing.T) {
+	onAfterCalledSyncing := false
+	onEnterCalledConnected := false
+
+	sm, err := NewBuilder().
+		WithInitialState(&State{
+			Name: "Disconnected",
+		}).
+		WithContext(context.Background()).
+		AddState(&State{
+			Name: "Connected",
+			OnEnter: func(ctx context.Context, trigger Trigger) error {
+				onEnterCalledConnected = true
+				return nil
+			},
+		}).
+		AddState(&State{
+			Name: "Idle",
+		}).
+		AddState(&State{
+			Name: "Syncing",
+			OnAfter: func(ctx context.Context, trigger Trigger) error {
+				onAfterCalledSyncing = true
+				return nil
+			},
+		}).
+		WithEventNames("GoToConnected", "GoToDisconnected", "GoToIdle", "GoToSyncing").
+		FromGraph(`
+			stateDiagram-v2
+			[*] --> Disconnected
+			Disconnected --> Connected
+			state Connected {
+				[*] --> Idle
+				Idle --> Syncing
+				Syncing --> Idle
+			}
+			Connected --> Disconnected
+		`).
+		Build()
+
+	if err != nil {
+		t.Fatalf("Creating the state machine should not have failed: %v", err)
+	}
+
+	sm.Start()
+
+	sm.TriggerEvent(event.WithName("GoToConnected"))
+
+	if !onEnterCalledConnected {
+		t.Error("Connected State, OnEnter should have been called")
+	}
+
+	if sm.CurrentState() != "Idle" {
+		t.Errorf("Connected State, should have entered Idle, got %s", sm.CurrentState())
+	}
+
+	err = sm.TriggerEvent(event.WithName("GoToSyncing"))
+	if err != nil {
+		t.Error("Idle State, should be possible to transition to Syncing")
+	}
+
+	err = sm.TriggerEvent(event.WithName("GoToDisconnected"))
+	if err != nil {
+		t.Error("Syncing State, should be possible to transition to Disconnected through Connected")
+	}
+
+	if !onAfterCalledSyncing {
+		t.Error("Syncing State, OnAfter should have been called")
+	}
+
+	if sm.CurrentState() != "Disconnected" {
+		t.Errorf("should be Disconnected, got %s", sm.CurrentState())
+	}
+}
+
+func TestStateMachine_FromBuilder_WithSubStates(t *testing.T) {
+	type Connect struct {
+	}
+
+	sm, err := NewBuilder().
+		WithInitialState(&State{
+			Name: "Disconnected",
+		}).
+		WithContext(context.Background()).
+		AddSubState("Connected", &State{
+			Name: "Idle",
+		}).
+		AddSubState("Connected", &State{
+			Name: "Syncing",
+		}).
+		AddState(&State{
+			Name: "Connected",
+		}).
+		WithInitialSubState("Connected", "Syncing").
+		AddTransition("Disconnected", Connect{}, "Connected").
+		Build()
+
+	if err != nil {
+		t.Fatalf("Creating the state machine should not have failed: %v", err)
+	}
+
+	sm.Start()
+	sm.TriggerEvent(Connect{})
+
+	if sm.CurrentState() != "Syncing" {
+		t.Errorf("Connected State, should have entered Syncing, got %s", sm.CurrentState())
+	}
+
+	_, err = NewBuilder().
+		WithInitialState(&State{
+			Name: "Disconnected",
+		}).
+		AddSubState("Unknown", &State{
+			Name: "Idle",
+		}).
+		Build()
+
+	if err == nil {
+		t.Error("Creating the state machine should have failed, unknown parent state")
+	}
+}
+
+func TestStateMachine_TriggerEvent_FromBuilder_WithGraph_WithGuards(t *testing.T) {
+	amount := 0
+
+	newStateMachine := func() *StateMachine {
+		sm, err := NewBuilder().
+			WithInitialState(&State{
+				Name: "Received",
+			}).
+			WithContext(context.Background()).
+			AddState(&State{
+				Name: "Review",
+			}).
+			AddState(&State{
+				Name: "Approved",
+			}).
+			WithEventForEntering("Review", event.WithName("Checked")).
+			WithEventForEntering("Approved", event.WithName("Checked")).
+			RegisterGuard("isBig", func(ctx context.Context, trigger Trigger) bool {
+				return amount > 100
+			}).
+			FromGraph(`
+				[*] --> Received
+				Received --> Review: [isBig]
+				Received --> Approved
+			`).
+			Build()
+
+		if err != nil {
+			t.Fatalf("Creating the state machine should not have failed: %v", err)
+		}
+
+		sm.Start()
+
+		return sm
+	}
+
+	amount = 1000
+	sm := newStateMachine()
+	sm.TriggerEvent(event.WithName("Checked"))
+
+	if sm.CurrentState() != "Review" {
+		t.Errorf("Received State, a big order should go to Review, got %s", sm.CurrentState())
+	}
+
+	amount = 10
+	sm = newStateMachine()
+	sm.TriggerEvent(event.WithName("Checked"))
+
+	if sm.CurrentState() != "Approved" {
+		t.Errorf("Received State, a small order should go to Approved, got %s", sm.CurrentState())
+	}
+
+	_, err := NewBuilder().
+		WithInitialState(&State{
+			Name: "Received",
+		}).
+		AddState(&State{
+			Name: "Review",
+		}).
+		WithEventNames("GoToReview").
+		FromGraph(`
+			Received --> Review: [unknown]
+		`).
+		Build()
+
+	if err == nil {
+		t.Error("Creating the state machine should have failed, unknown guard")
+	}
+}
+
+func TestStateMachine_TriggerEvent_FromBuilder_WithGraph_WithActions(t *testing.T) {
+	notified := false
+
+	hub := eventhub.NewEventHub(nil)
+
+	failed := make(chan TransitionFailed, 1)
+	hub.RegisterHandler(TransitionFailed{}, eventhub.ToHandler(TransitionFailed{}, func(ctx context.Context, e event.Event) error {
+		failed <- e.(TransitionFailed)
+		return nil
+	}))
+
+	sm, err := NewBuilder().
+		WithInitialState(&State{
+			Name: "A",
+		}).
+		WithContext(context.Background()).
+		AddState(&State{
+			Name: "B",
+		}).
+		AddState(&State{
+			Name: "C",
+			OnBefore: func(ctx context.Context, trigger Trigger) error {
+				return errors.New("I was asked to fail")
+			},
+		}).
+		AddState(&State{
+			Name: "Failed",
+		}).
+		WithEventNames("GoToB", "GoToC").
+		WithErrorState("Failed").
+		RegisterAction("notify", func(ctx context.Context) (action.Result, error) {
+			notified = true
+			return action.Nothing()
+		}).
+		SubscribeFrom(hub).
+		FromGraph(`
+			[*] --> A
+			A --> B: / notify
+			B --> C
+		`).
+		Build()
+
+	if err != nil {
+		t.Fatalf("Creating the state machine should not have failed: %v", err)
+	}
+
+	sm.Start()
+
+	if err := sm.TriggerEvent(event.WithName("GoToB")); err != nil || !notified {
+		t.Errorf("A State, the notify action should have run (%v)", err)
+	}
+
+	if err := sm.TriggerEvent(event.WithName("GoToC")); err == nil {
+		t.Error("B State, the transition to C should have failed")
+	}
+
+	if sm.CurrentState() != "Failed" {
+		t.Errorf("B State, should have entered the error state, got %s", sm.CurrentState())
+	}
+
+	select {
+	case e := <-failed:
+		if e.From != "B" || e.To != "C" || e.Err == nil {
+			t.Errorf("The published failure should describe the transition, got %v", e)
+		}
+	case <-time.After(time.Second):
+		t.Error("The failure should have been published on the hub")
+	}
+}
+
+func TestStateMachine_TriggerEvent_FromBuilder_WithGraph_Completion(t *testing.T) {
+	type GoToB struct {
+	}
+
+	type Cancel struct {
+	}
+
+	hub := eventhub.NewEventHub(nil)
+
+	sm, err := NewBuilder().
+		WithInitialState(&State{
+			Name: "A",
+		}).
+		WithContext(context.Background()).
+		AddState(&State{
+			Name: "B",
+		}).
+		WithEvents(GoToB{}).
+		SubscribeFrom(hub).
+		FromGraph(`
+			[*] --> A
+			A --> B
+			B --> [*]
+		`).
+		Build()
+
+	if err != nil {
+		t.Fatalf("Creating the state machine should not have failed: %v", err)
+	}
+
+	sm.Start()
+	hub.Publish(GoToB{}, nil).Wait()
+
+	if !sm.IsCompleted() {
+		t.Errorf("B State, should have completed as soon as it was entered, got %s", sm.CurrentState())
+	}
+
+	if len(sm.subscriptions) != 0 {
+		t.Error("A completed state machine should have unsubscribed from the hub")
+	}
+
+	sm, err = NewBuilder().
+		WithInitialState(&State{
+			Name: "A",
+		}).
+		WithContext(context.Background()).
+		WithEventForEntering(FinalState, Cancel{}).
+		SubscribeFrom(hub).
+		FromGraph(`
+			[*] --> A
+			A --> [*]
+		`).
+		Build()
+
+	if err != nil {
+		t.Fatalf("Creating the state machine should not have failed: %v", err)
+	}
+
+	sm.Start()
+
+	if sm.IsCompleted() {
+		t.Error("A State, should wait for Cancel")
+	}
+
+	hub.Publish(Cancel{}, nil).Wait()
+
+	if !sm.IsCompleted() {
+		t.Errorf("A State, should have completed on Cancel, got %s", sm.CurrentState())
+	}
+}
+
+func TestStateMachine_TriggerEvent_FromBuilder_WithLabelledGraph(t *testing.T) {
+	type Submit struct {
+	}
+
+	sm, err := NewBuilder().
+		WithInitialState(&State{
+			Name: "Draft",
+		}).
+		WithContext(context.Background()).
+		AddState(&State{
+			Name: "Review",
+		}).
+		AddState(&State{
+			Name: "Published",
+		}).
+		WithEvents(Submit{}).
+		FromGraph(`
+			stateDiagram-v2
+			direction LR
+			%% The editorial workflow
+			state "Work in progress" as Draft
+			note right of Review : Someone else has to approve
+			[*] --> Draft
+			Draft --> Review : Submit
+			Review --> Draft : Reject
+			Review --> Published : Approve
+			Published --> [*] : Archive
+		`).
+		Build()
+
+	if err != nil {
+		t.Fatalf("Creating the state machine should not have failed: %v", err)
+	}
+
+	sm.Start()
+
+	if err := sm.TriggerEvent(Submit{}); err != nil || sm.CurrentState() != "Review" {
+		t.Errorf("Draft State, Submit should lead to Review, got %s (%v)", sm.CurrentState(), err)
+	}
+
+	if err := sm.TriggerEvent(event.WithName("Reject")); err != nil || sm.CurrentState() != "Draft" {
+		t.Errorf("Review State, Reject should lead to Draft, got %s (%v)", sm.CurrentState(), err)
+	}
+
+	sm.TriggerEvent(Submit{})
+
+	if err := sm.TriggerEvent(event.WithName("Approve")); err != nil || sm.CurrentState() != "Published" {
+		t.Errorf("Review State, Approve should lead to Published, got %s (%v)", sm.CurrentState(), err)
+	}
+
+	if sm.IsCompleted() {
+		t.Error("Published State, should wait for Archive to complete")
+	}
+
+	sm.TriggerEvent(event.WithName("Archive"))
+
+	if !sm.IsCompleted() {
+		t.Error("Published State, Archive should have completed the state machine")
+	}
+
+	_, err = NewBuilder().
+		WithInitialState(&State{
+			Name: "Draft",
+		}).
+		FromGraph(`
+			Draft --> Review : Submit
+			Review -> Draft
+		`).
+		Build()
+
+	var syntaxErr *mermaid.SyntaxError
+	if !errors.As(err, &syntaxErr) || syntaxErr.Line != 3 {
+		t.Errorf("Creating the state machine should have failed with a syntax error at line 3, got %v", err)
+	}
+}
+
+func TestStateMachine_FromBuilder_WithGraph_UnknownSourceState(t *testing.T) {
+	_, err := NewBuilder().
+		WithInitialState(&State{Name: "A"}).
+		AddState(&State{Name: "B"}).
+		FromGraph(`
+			A --> B : Go
+			X --> A
+		`).
+		Build()
+
+	if !errors.Is(err, ErrUnknownState) {
+		t.Errorf("X State, should have been reported as unknown, got %v", err)
+	}
+}
+
+func TestStateMachine_FromBuilder_WithGraph_UnsupportedPseudoStates(t *testing.T) {
+	for _, kind := range []string{"choice", "fork", "join"} {
+		_, err := NewBuilder().
+			WithInitialState(&State{Name: "A"}).
+			AddState(&State{Name: "B"}).
+			RegisterGuard("ok", func(ctx context.Context, trigger Trigger) bool { return true }).
+			WithEventNames("Go").
+			FromGraph(fmt.Sprintf(`
+stateDiagram-v2
+    state check <<%s>>
+    [*] --> A
+    A --> check : Go
+    check --> B : [ok]
+`, kind)).
+			Build()
+
+		if !errors.Is(err, ErrUnsupportedPseudoState) || !strings.Contains(err.Error(), "check <<"+kind+">>") {
+			t.Errorf("<<%s>> should have been rejected as unsupported, got %v", kind, err)
+		}
+	}
+}
+
+func TestStateMachine_FromBuilder_WithGraph_InternalTransitions(t *testing.T) {
+	entered := 0
+	retries := 0
+
+	sm, err := NewBuilder().
+		WithInitialState(&State{Name: "Retrying", OnEnter: func(ctx context.Context, trigger Trigger) error {
+			entered++
+			return nil
+		}}).
+		RegisterAction("count", func(ctx context.Context) (action.Result, error) {
+			retries++
+			return action.Nothing()
+		}).
+		FromGraph(`
+			[*] --> Retrying
+			Retrying --> Retrying: internal Retry / count
+			Retrying --> Retrying: external Reset
+		`).
+		Build()
+
+	if err != nil {
+		t.Fatalf("Creating the state machine should not have failed: %v", err)
+	}
+
+	sm.Start()
+	sm.TriggerEvent(event.WithName("Retry"))
+	sm.TriggerEvent(event.WithName("Reset"))
+
+	if retries != 1 || entered != 2 {
+		t.Errorf("Retrying State, should only have been re-entered on Reset (entered %d, retries %d)", entered, retries)
+	}
+
+	if graph := sm.ToMermaid(); !strings.Contains(graph, "Retrying --> Retrying : internal Retry / count") {
+		t.Errorf("The internal transition should have been exported, got %s", graph)
+	}
+
+	_, err = NewBuilder().
+		WithInitialState(&State{Name: "A"}).
+		AddState(&State{Name: "B"}).
+		FromGraph(`
+			[*] --> A
+			A --> B: internal Go
+		`).
+		Build()
+
+	if err == nil {
+		t.Error("An internal transition to another state should have been rejected")
+	}
+}
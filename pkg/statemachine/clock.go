@@ -0,0 +1,32 @@
+package statemachine
+
+import "time"
+
+// Clock is the source of time of the state machine, replaceable to keep tests deterministic.
+type Clock interface {
+	Now() time.Time
+	// AfterFunc calls f, in its own goroutine, once the duration elapsed.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending call scheduled by a Clock.
+type Timer interface {
+	// Stop prevents the call from happening, it returns false if it already happened or was stopped.
+	Stop() bool
+}
+
+// SystemClock is the Clock based on the time package, used by default.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (SystemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// SetClock replaces the clock used for timed transitions and timestamps, it must be called before starting.
+func (sm *StateMachine) SetClock(clock Clock) {
+	sm.clock = clock
+}
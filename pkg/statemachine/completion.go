@@ -0,0 +1,121 @@
+package statemachine
+
+import (
+	"context"
+	"github.com/a-inacio/edt-go/pkg/action"
+)
+
+// FinalState is the name of the built-in final state, entering it completes the state machine.
+const FinalState = "[*]"
+
+// completionEventName is the event name of transitions taken as soon as their source state is entered.
+const completionEventName = ""
+
+// SetOnComplete defines a hook called once the final state is entered, its outcome becomes the state machine result.
+func (sm *StateMachine) SetOnComplete(onComplete func(ctx context.Context, trigger Trigger) (action.Result, error)) {
+	sm.onComplete = onComplete
+}
+
+// Done returns a channel closed once the state machine completes.
+func (sm *StateMachine) Done() <-chan struct{} {
+	return sm.done
+}
+
+// IsCompleted tells if the state machine entered its final state.
+func (sm *StateMachine) IsCompleted() bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	return sm.completed
+}
+
+// Result returns the outcome of the on complete hook, only meaningful once Done is closed.
+func (sm *StateMachine) Result() (action.Result, error) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	return sm.result, sm.err
+}
+
+// afterEntering completes the state machine when the final state was entered, otherwise takes the completion
+// transitions of the entered states, if any. Entering the final state of the last region of a composite state still
+// running takes the completion transitions of the composite state.
+func (sm *StateMachine) afterEntering(trigger *Trigger) error {
+	entered := sm.entered
+	sm.entered = nil
+
+	var failure error
+
+	for _, node := range entered {
+		// A previous completion transition may have left it
+		if !sm.isActiveLeaf(node) {
+			continue
+		}
+
+		if node.Type == TerminalNode && node.Parent == nil {
+			sm.complete(trigger)
+			return nil
+		}
+
+		source := node
+		if node.Type == TerminalNode {
+			if !sm.isCompleted(node.Parent) {
+				continue
+			}
+
+			source = node.Parent
+		}
+
+		if err := sm.takeCompletionTransition(source, node, trigger); err != nil && failure == nil {
+			failure = err
+		}
+	}
+
+	return failure
+}
+
+// takeCompletionTransition takes the first completion transition of the source state whose guard passes.
+func (sm *StateMachine) takeCompletionTransition(source *Node, leaf *Node, trigger *Trigger) error {
+	for _, t := range source.Transitions[completionEventName] {
+		completionTrigger := Trigger{
+			Event:     trigger.Event,
+			FromState: source.State,
+			ToState:   t.To.State,
+		}
+
+		if t.Guard == nil || t.Guard(sm.context, completionTrigger) {
+			return sm.executeTransition(source, leaf, &completionTrigger, &t)
+		}
+	}
+
+	return nil
+}
+
+func (sm *StateMachine) complete(trigger *Trigger) {
+	var res action.Result
+	var err error
+
+	if sm.onComplete != nil {
+		res, err = sm.onComplete(sm.context, *trigger)
+	}
+
+	sm.mu.Lock()
+	sm.completed = true
+	sm.changed = true
+	sm.deferred = nil
+	sm.result = res
+	sm.err = err
+	sm.mu.Unlock()
+
+	sm.unsubscribe()
+	sm.syncTimers()
+	sm.syncActivities()
+
+	close(sm.done)
+
+	if sm.onDone != nil {
+		sm.onDone()
+	}
+
+	sm.publish(MachineCompleted{Machine: sm.id, Result: res, Err: err})
+}
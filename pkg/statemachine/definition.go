@@ -0,0 +1,101 @@
+package statemachine
+
+import (
+	"context"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Definition is a compiled state machine: its states and transitions are built once and shared, read-only, by all
+// the state machines created from it, each of them only holding its own active states, history and timers.
+type Definition struct {
+	template *StateMachine
+	events   []string
+}
+
+// Compile builds the definition of the state machine, see Definition.
+// The hub given to SubscribeFrom is not subscribed to, it is the Manager of the instances that subscribes to it.
+func (builder *StateMachineBuilder) Compile() (*Definition, error) {
+	hub := builder.hub
+	builder.hub = nil
+	template, err := builder.Build()
+	builder.hub = hub
+
+	if err != nil {
+		return nil, err
+	}
+
+	template.shared = true
+
+	seen := map[string]bool{}
+	var events []string
+
+	for _, name := range template.order {
+		for eventName := range template.nodes[name].Transitions {
+			if eventName == completionEventName || strings.HasPrefix(eventName, timedEventPrefix) || seen[eventName] {
+				continue
+			}
+
+			seen[eventName] = true
+			events = append(events, eventName)
+		}
+	}
+
+	sort.Strings(events)
+
+	return &Definition{template: template, events: events}, nil
+}
+
+// EventNames returns the names of the events the state machine has transitions for, timed and completion transitions
+// excluded.
+func (d *Definition) EventNames() []string {
+	return append([]string{}, d.events...)
+}
+
+// New creates a state machine, not started yet, from the definition.
+// Its states and transitions cannot be changed (ErrSharedDefinition is returned), everything else can.
+func (d *Definition) New(id string, ctx context.Context) *StateMachine {
+	t := d.template
+
+	sm := &StateMachine{
+		l:           t.l,
+		nodes:       t.nodes,
+		order:       t.order,
+		transitions: t.transitions,
+		context:     ctx,
+		initial:     t.initial,
+		errorState:  t.errorState,
+		hub:         t.hub,
+		onComplete:  t.onComplete,
+		queueSize:   t.queueSize,
+		queuePolicy: t.queuePolicy,
+		unhandled:   t.unhandled,
+		fallback:    t.fallback,
+		done:        make(chan struct{}),
+		id:          id,
+		version:     t.version,
+		historySize: t.historySize,
+		lastActive:  map[string]string{},
+		store:       t.store,
+		clock:       t.clock,
+		timers:      map[timerKey]*armedTimer{},
+		activities:  map[*Node]*runningActivity{},
+		shared:      true,
+	}
+
+	sm.cond = sync.NewCond(&sm.mu)
+
+	return sm
+}
+
+// hubEvents returns the events to subscribe to on the hub, by name.
+func (d *Definition) hubEvents() []event.Event {
+	events := make([]event.Event, len(d.events))
+	for i, name := range d.events {
+		events[i] = event.WithName(name)
+	}
+
+	return events
+}
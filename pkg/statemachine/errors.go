@@ -0,0 +1,58 @@
+package statemachine
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrGuardRejected is returned when the current state has transitions for an event but none of their guards passed.
+var ErrGuardRejected = errors.New("no guard allowed the transition")
+
+// TransitionError is returned when a hook or the action of a transition fails.
+type TransitionError struct {
+	From  string
+	To    string
+	Event string
+	// Err is the failure that aborted the transition.
+	Err error
+	// ErrorStateErr is the failure entering the error state, if any.
+	ErrorStateErr error
+}
+
+func (e *TransitionError) Error() string {
+	msg := fmt.Sprintf("transition from %s to %s on %s failed: %v", e.From, e.To, e.Event, e.Err)
+
+	if e.ErrorStateErr != nil {
+		msg = fmt.Sprintf("%s (entering the error state also failed: %v)", msg, e.ErrorStateErr)
+	}
+
+	return msg
+}
+
+func (e *TransitionError) Unwrap() error {
+	return e.Err
+}
+
+// ErrUnhandledEvent is returned when no active state has a transition for an event, see UnhandledPolicy.
+var ErrUnhandledEvent = errors.New("unhandled event")
+
+// ErrEventDropped is returned when an event is discarded because the queue of pending events is full.
+var ErrEventDropped = errors.New("event dropped, queue is full")
+
+// ErrCompleted is returned when an event is triggered on a state machine that already completed.
+var ErrCompleted = errors.New("state machine completed")
+
+// ErrUnknownState is returned when a state is referenced before being added.
+var ErrUnknownState = errors.New("unknown state")
+
+// ErrNondeterministicTransition is returned when a state has several transitions for the same event that could be
+// taken at the same time.
+var ErrNondeterministicTransition = errors.New("nondeterministic transition")
+
+// ErrUnsupportedPseudoState is returned when building from a graph declaring a pseudo-state with no semantics in
+// the state machine: <<choice>>, <<fork>> or <<join>>.
+var ErrUnsupportedPseudoState = errors.New("unsupported pseudo-state")
+
+// ErrSharedDefinition is returned when changing the states or transitions of an instance created from a Definition,
+// they are shared by all its instances.
+var ErrSharedDefinition = errors.New("the definition of the state machine is shared, it cannot be changed")
@@ -0,0 +1,84 @@
+package statemachine
+
+import (
+	"github.com/a-inacio/edt-go/pkg/action"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"github.com/a-inacio/edt-go/pkg/eventhub"
+)
+
+// The following events are published on the hub the state machine is wired to, Machine being the id of the state
+// machine (see SetID) and Event the name of the triggering event, empty when starting and for completion transitions.
+
+// StateEntered is published when a state was entered, once its hooks succeeded.
+type StateEntered struct {
+	Machine string
+	State   string
+	// From is the innermost state the transition was taken from, empty when starting.
+	From  string
+	Event string
+}
+
+// StateExited is published when a state was left, once its hook succeeded.
+type StateExited struct {
+	Machine string
+	State   string
+	// To is the target of the transition.
+	To    string
+	Event string
+}
+
+// TransitionRejected is published when an event cannot be processed: no transition, no guard passing or the state
+// machine already completed.
+type TransitionRejected struct {
+	Machine string
+	// State is the current state, see StateMachine.CurrentState.
+	State  string
+	Event  string
+	Reason error
+}
+
+// TransitionFailed is published on the hub when a transition fails.
+type TransitionFailed struct {
+	Machine string
+	From    string
+	To      string
+	Event   string
+	Err     error
+}
+
+// MachineCompleted is published when the state machine entered its final state, with the outcome of its on complete
+// hook.
+type MachineCompleted struct {
+	Machine string
+	Result  action.Result
+	Err     error
+}
+
+// PublishTo makes the state machine publish its lifecycle events on the hub, which is done by default on the hub it
+// subscribes from.
+func (sm *StateMachine) PublishTo(hub *eventhub.EventHub) {
+	sm.publishHub = hub
+}
+
+// publish publishes an event on the hub the state machine is wired to, if any.
+func (sm *StateMachine) publish(e event.Event) {
+	hub := sm.publishHub
+	if hub == nil {
+		hub = sm.hub
+	}
+
+	if hub == nil {
+		return
+	}
+
+	hub.Publish(e, sm.context)
+}
+
+// triggerEventName returns the name of the event of a trigger, empty if there is none.
+func triggerEventName(trigger *Trigger) string {
+	if trigger.Event == nil {
+		return ""
+	}
+
+	return event.GetName(*trigger.Event)
+}
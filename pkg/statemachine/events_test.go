@@ -0,0 +1,136 @@
+package statemachine
+
+import (
+	"context"
+	"errors"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"github.com/a-inacio/edt-go/pkg/eventhub"
+	"testing"
+	"time"
+)
+
+func listen[T event.Event](hub *eventhub.EventHub) chan T {
+	received := make(chan T, 10)
+
+	var e T
+	hub.RegisterHandler(e, eventhub.ToHandler(e, func(ctx context.Context, e event.Event) error {
+		received <- e.(T)
+		return nil
+	}))
+
+	return received
+}
+
+func receive[T any](t *testing.T, received chan T) T {
+	t.Helper()
+
+	select {
+	case e := <-received:
+		return e
+	case <-time.After(time.Second):
+		var e T
+		t.Fatalf("%T should have been published", e)
+		return e
+	}
+}
+
+func TestStateMachine_LifecycleEvents(t *testing.T) {
+	hub := eventhub.NewEventHub(nil)
+
+	entered := listen[StateEntered](hub)
+	exited := listen[StateExited](hub)
+	rejected := listen[TransitionRejected](hub)
+	completed := listen[MachineCompleted](hub)
+
+	sm, err := NewBuilder().
+		WithInitialState(&State{Name: "A"}).
+		AddState(&State{Name: "B"}).
+		WithID("order-1").
+		SubscribeFrom(hub).
+		FromGraph(`
+			[*] --> A
+			A --> B: GoToB
+			B --> [*]: Finish
+		`).
+		Build()
+
+	if err != nil {
+		t.Fatalf("Creating the state machine should not have failed: %v", err)
+	}
+
+	sm.Start()
+
+	if e := receive(t, entered); e != (StateEntered{Machine: "order-1", State: "A"}) {
+		t.Errorf("A State, should have been entered on start, got %+v", e)
+	}
+
+	sm.TriggerEvent(event.WithName("Finish"))
+
+	if e := receive(t, rejected); e.Machine != "order-1" || e.State != "A" || e.Event != "Finish" || e.Reason == nil {
+		t.Errorf("A State, should have rejected Finish, got %+v", e)
+	}
+
+	sm.TriggerEvent(event.WithName("GoToB"))
+
+	if e := receive(t, exited); e != (StateExited{Machine: "order-1", State: "A", To: "B", Event: "GoToB"}) {
+		t.Errorf("A State, should have been exited, got %+v", e)
+	}
+
+	if e := receive(t, entered); e != (StateEntered{Machine: "order-1", State: "B", From: "A", Event: "GoToB"}) {
+		t.Errorf("B State, should have been entered, got %+v", e)
+	}
+
+	sm.TriggerEvent(event.WithName("Finish"))
+
+	if e := receive(t, completed); e.Machine != "order-1" || e.Err != nil {
+		t.Errorf("The state machine should have completed, got %+v", e)
+	}
+}
+
+func TestStateMachine_LifecycleEvents_PublishTo(t *testing.T) {
+	hub := eventhub.NewEventHub(nil)
+	rejected := listen[TransitionRejected](hub)
+
+	sm, _ := NewStateMachine(&State{Name: "A"}, context.Background())
+	sm.AddState(&State{Name: "B"})
+	sm.AddTransition("A", event.WithName("GoToB"), "B", WithGuard(func(ctx context.Context, trigger Trigger) bool {
+		return false
+	}))
+	sm.PublishTo(hub)
+
+	sm.Start()
+	sm.TriggerEvent(event.WithName("GoToB"))
+
+	if e := receive(t, rejected); !errors.Is(e.Reason, ErrGuardRejected) {
+		t.Errorf("The rejection should tell the guard did not pass, got %+v", e)
+	}
+}
+
+func TestStateMachine_PublishTo_AnotherHub(t *testing.T) {
+	subscribed := eventhub.NewEventHub(nil)
+	published := eventhub.NewEventHub(nil)
+
+	completed := listen[MachineCompleted](published)
+
+	sm, _ := NewBuilder().
+		WithInitialState(&State{Name: "A"}).
+		SubscribeFrom(subscribed).
+		FromGraph(`
+			[*] --> A
+			A --> [*]: Finish
+		`).
+		Build()
+
+	sm.PublishTo(published)
+	sm.Start()
+
+	if err := subscribed.PublishAndCollect(event.WithName("Finish"), nil); err != nil {
+		t.Fatalf("Finish should have been handled, got %v", err)
+	}
+
+	receive(t, completed)
+
+	if err := subscribed.PublishAndCollect(event.WithName("Finish"), nil); err != nil {
+		t.Errorf("A completed state machine should have unsubscribed from the hub it subscribed from, got %v", err)
+	}
+}
@@ -0,0 +1,408 @@
+package statemachine
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Description is a snapshot of the definition of a state machine, including which states are active.
+type Description struct {
+	Initial     string                  `json:"initial"`
+	Current     string                  `json:"current,omitempty"`
+	Completed   bool                    `json:"completed"`
+	States      []StateDescription      `json:"states"`
+	Transitions []TransitionDescription `json:"transitions"`
+	Events      []string                `json:"events"`
+}
+
+// StateDescription describes a state, states are listed in the order they were added.
+type StateDescription struct {
+	Name string `json:"name"`
+	// Parent is the enclosing composite state, empty for top level states.
+	Parent string `json:"parent,omitempty"`
+	// Initial is the initial sub-state of a composite state.
+	Initial string `json:"initial,omitempty"`
+	// Regions are the initial sub-states of each concurrent region of a parallel state.
+	Regions []string `json:"regions,omitempty"`
+	// Region is the concurrent region of the parent the state belongs to.
+	Region  int  `json:"region,omitempty"`
+	IsFinal bool `json:"final,omitempty"`
+	// History is set for history pseudo-states: history (shallow) or deepHistory.
+	History  string `json:"history,omitempty"`
+	IsActive bool   `json:"active,omitempty"`
+}
+
+// TransitionDescription describes a transition, transitions are listed in the order they were added.
+type TransitionDescription struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Event is the name of the triggering event, empty for completion transitions.
+	Event  string `json:"event,omitempty"`
+	Guard  string `json:"guard,omitempty"`
+	Action string `json:"action,omitempty"`
+	// Internal tells the transition runs its action without leaving the state.
+	Internal bool `json:"internal,omitempty"`
+}
+
+// Describe returns a description of the state machine, the base of all exports.
+func (sm *StateMachine) Describe() *Description {
+	sm.mu.Lock()
+	current := sm.current
+	completed := sm.completed
+	sm.mu.Unlock()
+
+	d := &Description{
+		Initial:   sm.initial,
+		Completed: completed,
+	}
+
+	active := map[string]bool{}
+	if current != "" {
+		d.Current = current
+
+		for _, leaf := range sm.activeLeaves() {
+			for n := leaf; n != nil; n = n.Parent {
+				active[n.State.Name] = true
+			}
+		}
+	}
+
+	var transitions []Transition
+	from := map[int]string{}
+	events := map[string]bool{}
+
+	for _, name := range sm.states() {
+		node := sm.nodes[name]
+
+		state := StateDescription{
+			Name:     name,
+			IsFinal:  node.Type == TerminalNode,
+			IsActive: active[name],
+		}
+
+		if node.Parent != nil {
+			state.Parent = node.Parent.State.Name
+		}
+
+		if node.Initial != nil {
+			state.Initial = node.Initial.State.Name
+		}
+
+		if node.IsParallel() {
+			for _, initial := range node.RegionInitials {
+				state.Regions = append(state.Regions, initial.State.Name)
+			}
+		}
+
+		state.Region = node.Region
+
+		if node.Type == HistoryNode {
+			state.History = historyStereotypes[node.History]
+		}
+
+		d.States = append(d.States, state)
+
+		for eventName, ts := range node.Transitions {
+			if eventName != completionEventName {
+				events[eventName] = true
+			}
+
+			for _, t := range ts {
+				transitions = append(transitions, t)
+				from[t.seq] = name
+			}
+		}
+	}
+
+	sort.Slice(transitions, func(i, j int) bool {
+		return transitions[i].seq < transitions[j].seq
+	})
+
+	for _, t := range transitions {
+		transition := TransitionDescription{
+			From:     from[t.seq],
+			To:       t.To.State.Name,
+			Event:    t.EventName,
+			Guard:    t.GuardName,
+			Action:   t.ActionName,
+			Internal: t.Kind == InternalTransition,
+		}
+
+		if transition.Guard == "" && t.Guard != nil {
+			transition.Guard = "guard"
+		}
+
+		if transition.Action == "" && (t.Action != nil || t.effect != nil) {
+			transition.Action = "action"
+		}
+
+		d.Transitions = append(d.Transitions, transition)
+	}
+
+	for e := range events {
+		d.Events = append(d.Events, e)
+	}
+
+	sort.Strings(d.Events)
+
+	return d
+}
+
+// ToJSON exports the state machine as JSON, see Description.
+func (sm *StateMachine) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(sm.Describe(), "", "  ")
+}
+
+// ToMermaid exports the state machine as a Mermaid stateDiagram-v2, the active states are highlighted.
+// The output can be loaded back with FromGraph, event names being transition labels.
+func (sm *StateMachine) ToMermaid() string {
+	d := sm.Describe()
+	ids := mermaidIds(d)
+
+	var b strings.Builder
+	b.WriteString("stateDiagram-v2\n")
+
+	for _, s := range d.States {
+		if ids[s.Name] != s.Name && !s.IsFinal {
+			fmt.Fprintf(&b, "    state \"%s\" as %s\n", s.Name, ids[s.Name])
+		}
+	}
+
+	writeMermaidScope(&b, d, ids, "", "    ")
+
+	var active []string
+	for _, s := range d.States {
+		if s.IsActive && !s.IsFinal {
+			active = append(active, ids[s.Name])
+		}
+	}
+
+	if len(active) > 0 {
+		b.WriteString("    classDef current font-weight:bold,stroke-width:3px\n")
+		fmt.Fprintf(&b, "    class %s current\n", strings.Join(active, ","))
+	}
+
+	return b.String()
+}
+
+// writeMermaidScope writes the content of a composite state (or of the state machine, for an empty scope): its
+// initial transition, its composite sub-states and the transitions between its sub-states, region by region.
+func writeMermaidScope(b *strings.Builder, d *Description, ids map[string]string, scope string, indent string) {
+	initials := []string{d.Initial}
+	parents := map[string]string{}
+	regions := map[string]int{}
+
+	for _, s := range d.States {
+		parents[s.Name] = s.Parent
+		regions[s.Name] = s.Region
+
+		if s.Name == scope {
+			initials = []string{s.Initial}
+
+			if len(s.Regions) > 0 {
+				initials = s.Regions
+			}
+		}
+	}
+
+	for region, initial := range initials {
+		if region > 0 {
+			fmt.Fprintf(b, "%s--\n", indent)
+		}
+
+		fmt.Fprintf(b, "%s[*] --> %s\n", indent, ids[initial])
+
+		for _, s := range d.States {
+			if s.Parent == scope && s.Region == region && s.History != "" {
+				fmt.Fprintf(b, "%sstate %s <<%s>>\n", indent, ids[s.Name], s.History)
+			}
+		}
+
+		for _, s := range d.States {
+			if s.Parent != scope || s.Region != region || s.Initial == "" {
+				continue
+			}
+
+			fmt.Fprintf(b, "%sstate %s {\n", indent, ids[s.Name])
+			writeMermaidScope(b, d, ids, s.Name, indent+"    ")
+			fmt.Fprintf(b, "%s}\n", indent)
+		}
+
+		for _, t := range d.Transitions {
+			if transitionScope(parents, t) != scope || regionOf(parents, regions, scope, t.From) != region {
+				continue
+			}
+
+			fmt.Fprintf(b, "%s%s --> %s", indent, ids[t.From], ids[t.To])
+
+			if label := transitionLabelOf(t); label != "" {
+				fmt.Fprintf(b, " : %s", label)
+			}
+
+			b.WriteString("\n")
+		}
+	}
+}
+
+// regionOf returns the region of the scope a state belongs to, through its ancestor right below the scope.
+func regionOf(parents map[string]string, regions map[string]int, scope string, state string) int {
+	for s := state; s != ""; s = parents[s] {
+		if parents[s] == scope {
+			return regions[s]
+		}
+	}
+
+	return 0
+}
+
+// ToDOT exports the state machine as a Graphviz digraph, composite states are drawn as clusters and the active states
+// are highlighted.
+func (sm *StateMachine) ToDOT() string {
+	d := sm.Describe()
+
+	var b strings.Builder
+	b.WriteString("digraph StateMachine {\n")
+	b.WriteString("    compound=true;\n")
+	b.WriteString("    node [shape=box, style=rounded];\n")
+	b.WriteString("    \"__initial__\" [shape=point];\n")
+	fmt.Fprintf(&b, "    \"__initial__\" -> %q;\n", d.Initial)
+
+	writeDOTScope(&b, d, "", "    ")
+
+	for _, t := range d.Transitions {
+		fmt.Fprintf(&b, "    %q -> %q", t.From, t.To)
+
+		if label := transitionLabelOf(t); label != "" {
+			fmt.Fprintf(&b, " [label=%q]", label)
+		}
+
+		b.WriteString(";\n")
+	}
+
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// writeDOTScope writes the states of a scope, a composite state becomes a cluster where its own node is the point
+// pointing to its initial sub-state.
+func writeDOTScope(b *strings.Builder, d *Description, scope string, indent string) {
+	for _, s := range d.States {
+		if s.Parent != scope {
+			continue
+		}
+
+		switch {
+		case s.IsFinal:
+			fmt.Fprintf(b, "%s%q [shape=doublecircle, label=\"\", width=0.2];\n", indent, s.Name)
+		case s.History != "":
+			label := "H"
+			if s.History == historyStereotypes[DeepHistory] {
+				label = "H*"
+			}
+
+			fmt.Fprintf(b, "%s%q [shape=circle, label=%q];\n", indent, s.Name, label)
+		case s.Initial != "":
+			fmt.Fprintf(b, "%ssubgraph %q {\n", indent, "cluster_"+s.Name)
+			fmt.Fprintf(b, "%s    label=%q;\n", indent, s.Name)
+
+			if s.IsActive {
+				fmt.Fprintf(b, "%s    penwidth=3;\n", indent)
+			}
+
+			fmt.Fprintf(b, "%s    %q [shape=point];\n", indent, s.Name)
+
+			initials := []string{s.Initial}
+			if len(s.Regions) > 0 {
+				initials = s.Regions
+			}
+
+			for _, initial := range initials {
+				fmt.Fprintf(b, "%s    %q -> %q;\n", indent, s.Name, initial)
+			}
+			writeDOTScope(b, d, s.Name, indent+"    ")
+			fmt.Fprintf(b, "%s}\n", indent)
+		case s.IsActive:
+			fmt.Fprintf(b, "%s%q [style=\"rounded,bold\", penwidth=3];\n", indent, s.Name)
+		default:
+			fmt.Fprintf(b, "%s%q;\n", indent, s.Name)
+		}
+	}
+}
+
+// transitionScope returns the innermost composite state enclosing both ends of a transition, where Mermaid expects
+// it to be declared.
+func transitionScope(parents map[string]string, t TransitionDescription) string {
+	for scope := parents[t.From]; scope != ""; scope = parents[scope] {
+		for p := parents[t.To]; p != ""; p = parents[p] {
+			if p == scope {
+				return scope
+			}
+		}
+	}
+
+	return ""
+}
+
+// transitionLabelOf returns the label of a transition, in the syntax understood by FromGraph: `Event [guard] / action`.
+func transitionLabelOf(t TransitionDescription) string {
+	var parts []string
+
+	if t.Internal {
+		parts = append(parts, internalKeyword)
+	}
+
+	if t.Event != "" {
+		parts = append(parts, t.Event)
+	}
+
+	if t.Guard != "" {
+		parts = append(parts, "["+t.Guard+"]")
+	}
+
+	if t.Action != "" {
+		parts = append(parts, "/ "+t.Action)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// mermaidIds maps state names to Mermaid identifiers, names that are not valid identifiers get a generated one.
+func mermaidIds(d *Description) map[string]string {
+	ids := map[string]string{}
+
+	for i, s := range d.States {
+		switch {
+		case s.IsFinal:
+			ids[s.Name] = FinalState
+		case isMermaidId(s.Name):
+			ids[s.Name] = s.Name
+		default:
+			ids[s.Name] = fmt.Sprintf("state%d", i)
+		}
+	}
+
+	return ids
+}
+
+func isMermaidId(name string) bool {
+	if name == "state" || name == "note" || name == "direction" {
+		return false
+	}
+
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			return false
+		}
+	}
+
+	return name != ""
+}
+
+// states returns the state names in the order they were added, the final state last.
+func (sm *StateMachine) states() []string {
+	return append(append([]string{}, sm.order...), FinalState)
+}
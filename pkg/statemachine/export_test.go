@@ -0,0 +1,193 @@
+package statemachine
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/a-inacio/edt-go/pkg/action"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const exportableGraph = `
+	stateDiagram-v2
+	[*] --> Disconnected
+	Disconnected --> Connected : Connect / notify
+	state Connected {
+		[*] --> Idle
+		Idle --> Syncing : Sync [hasData]
+		Syncing --> Idle : Synced
+	}
+	Connected --> Disconnected : Disconnect
+	Connected --> Failed : Error
+	Failed --> [*]
+`
+
+func TestStateMachine_ToJSON(t *testing.T) {
+	sm, err := NewBuilder().
+		WithInitialState(&State{Name: "Disconnected"}).
+		WithContext(context.Background()).
+		AddState(&State{Name: "Connected"}).
+		AddState(&State{Name: "Idle"}).
+		AddState(&State{Name: "Syncing"}).
+		AddState(&State{Name: "Failed"}).
+		RegisterGuard("hasData", func(ctx context.Context, trigger Trigger) bool {
+			return true
+		}).
+		RegisterAction("notify", action.DoNothing).
+		FromGraph(exportableGraph).
+		Build()
+
+	if err != nil {
+		t.Fatalf("Creating the state machine should not have failed: %v", err)
+	}
+
+	sm.Start()
+	sm.TriggerEvent(event.WithName("Connect"))
+
+	data, err := sm.ToJSON()
+	if err != nil {
+		t.Fatalf("Exporting should not have failed: %v", err)
+	}
+
+	var d Description
+	if err := json.Unmarshal(data, &d); err != nil {
+		t.Fatalf("The export should be valid JSON: %v", err)
+	}
+
+	if d.Initial != "Disconnected" || d.Current != "Idle" {
+		t.Errorf("The initial and current states should be exported, got %s and %s", d.Initial, d.Current)
+	}
+
+	if len(d.States) != 6 || len(d.Transitions) != 6 {
+		t.Errorf("Every state and transition should be exported, got %d states and %d transitions", len(d.States), len(d.Transitions))
+	}
+
+	expectedEvents := []string{"Connect", "Disconnect", "Error", "Sync", "Synced"}
+	if !reflect.DeepEqual(d.Events, expectedEvents) {
+		t.Errorf("Every event should be exported, expected %v, got %v", expectedEvents, d.Events)
+	}
+
+	sync := d.Transitions[1]
+	if sync.From != "Idle" || sync.To != "Syncing" || sync.Event != "Sync" || sync.Guard != "hasData" {
+		t.Errorf("Transitions should be exported in declaration order, got %v", sync)
+	}
+
+	for _, s := range d.States {
+		active := s.Name == "Connected" || s.Name == "Idle"
+		if s.IsActive != active {
+			t.Errorf("State %s should be active: %v", s.Name, active)
+		}
+
+		if s.Name == "Idle" && s.Parent != "Connected" {
+			t.Errorf("Idle should be nested in Connected, got %s", s.Parent)
+		}
+	}
+}
+
+func TestStateMachine_ToMermaid(t *testing.T) {
+	sm, err := NewBuilder().
+		WithInitialState(&State{Name: "Disconnected"}).
+		WithContext(context.Background()).
+		AddState(&State{Name: "Connected"}).
+		AddState(&State{Name: "Idle"}).
+		AddState(&State{Name: "Syncing"}).
+		AddState(&State{Name: "Failed"}).
+		RegisterGuard("hasData", func(ctx context.Context, trigger Trigger) bool {
+			return true
+		}).
+		RegisterAction("notify", action.DoNothing).
+		FromGraph(exportableGraph).
+		Build()
+
+	if err != nil {
+		t.Fatalf("Creating the state machine should not have failed: %v", err)
+	}
+
+	sm.Start()
+
+	graph := sm.ToMermaid()
+
+	for _, expected := range []string{
+		"[*] --> Disconnected",
+		"Disconnected --> Connected : Connect / notify",
+		"state Connected {",
+		"Idle --> Syncing : Sync [hasData]",
+		"Failed --> [*]",
+		"class Disconnected current",
+	} {
+		if !strings.Contains(graph, expected) {
+			t.Errorf("The export should contain %q, got:\n%s", expected, graph)
+		}
+	}
+
+	// The export can be loaded back, without drifting (transitions of composite states come first)
+	reloaded, err := NewBuilder().
+		WithInitialState(&State{Name: "Disconnected"}).
+		WithContext(context.Background()).
+		AddState(&State{Name: "Connected"}).
+		AddState(&State{Name: "Idle"}).
+		AddState(&State{Name: "Syncing"}).
+		AddState(&State{Name: "Failed"}).
+		RegisterGuard("hasData", func(ctx context.Context, trigger Trigger) bool {
+			return true
+		}).
+		RegisterAction("notify", action.DoNothing).
+		FromGraph(graph).
+		Build()
+
+	if err != nil {
+		t.Fatalf("The exported graph should load back: %v", err)
+	}
+
+	sorted := func(transitions []TransitionDescription) []TransitionDescription {
+		sort.Slice(transitions, func(i, j int) bool {
+			return transitions[i].From+transitions[i].To < transitions[j].From+transitions[j].To
+		})
+		return transitions
+	}
+
+	if !reflect.DeepEqual(sorted(reloaded.Describe().Transitions), sorted(sm.Describe().Transitions)) {
+		t.Errorf("The exported graph should describe the same transitions, got:\n%s", graph)
+	}
+}
+
+func TestStateMachine_ToDOT(t *testing.T) {
+	sm, err := NewBuilder().
+		WithInitialState(&State{Name: "Disconnected"}).
+		WithContext(context.Background()).
+		AddState(&State{Name: "Connected"}).
+		AddState(&State{Name: "Idle"}).
+		AddState(&State{Name: "Syncing"}).
+		AddState(&State{Name: "Failed"}).
+		RegisterGuard("hasData", func(ctx context.Context, trigger Trigger) bool {
+			return true
+		}).
+		RegisterAction("notify", action.DoNothing).
+		FromGraph(exportableGraph).
+		Build()
+
+	if err != nil {
+		t.Fatalf("Creating the state machine should not have failed: %v", err)
+	}
+
+	sm.Start()
+	sm.TriggerEvent(event.WithName("Connect"))
+
+	graph := sm.ToDOT()
+
+	for _, expected := range []string{
+		"digraph StateMachine {",
+		`"__initial__" -> "Disconnected";`,
+		`subgraph "cluster_Connected" {`,
+		`"Idle" [style="rounded,bold", penwidth=3];`,
+		`"Idle" -> "Syncing" [label="Sync [hasData]"];`,
+		`"[*]" [shape=doublecircle`,
+	} {
+		if !strings.Contains(graph, expected) {
+			t.Errorf("The export should contain %q, got:\n%s", expected, graph)
+		}
+	}
+}
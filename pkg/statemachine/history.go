@@ -0,0 +1,161 @@
+package statemachine
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultHistorySize is the number of transitions kept in the history log, unless changed by SetHistorySize.
+const DefaultHistorySize = 100
+
+// HistoryEntry records a transition that was taken.
+type HistoryEntry struct {
+	At   time.Time `json:"at"`
+	From string    `json:"from,omitempty"`
+	To   string    `json:"to"`
+	// Event is the name of the triggering event, empty when starting and for completion transitions.
+	Event string `json:"event,omitempty"`
+	// Duration is how long the state machine stayed in the From state.
+	Duration time.Duration `json:"duration"`
+}
+
+// HistoryType defines what a history pseudo-state resumes when a composite state is re-entered through it.
+type HistoryType int
+
+const (
+	// ShallowHistory resumes the last active direct sub-state, entering its initial sub-states.
+	ShallowHistory HistoryType = iota
+	// DeepHistory resumes the last active state, at any depth.
+	DeepHistory
+)
+
+// historyStereotypes are the names of the history types, as stereotypes of the graph: `state H <<history>>`.
+var historyStereotypes = map[HistoryType]string{
+	ShallowHistory: "history",
+	DeepHistory:    "deepHistory",
+}
+
+// SetHistorySize bounds the number of transitions kept in the history log, the oldest are discarded first.
+// A size of zero disables the history log.
+func (sm *StateMachine) SetHistorySize(size int) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.historySize = size
+
+	if len(sm.history) > size {
+		sm.history = append([]HistoryEntry{}, sm.history[len(sm.history)-size:]...)
+	}
+}
+
+// History returns the transitions taken, oldest first, up to the history size.
+func (sm *StateMachine) History() []HistoryEntry {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	return append([]HistoryEntry{}, sm.history...)
+}
+
+// AddHistoryState adds a history pseudo-state to a composite state.
+// A transition targeting it re-enters the composite state where it was last left, or through its initial sub-state if
+// it was never entered.
+func (sm *StateMachine) AddHistoryState(parentStateName string, name string, historyType HistoryType) error {
+	if sm.shared {
+		return ErrSharedDefinition
+	}
+
+	parent, ok := sm.nodes[parentStateName]
+	if !ok {
+		return fmt.Errorf("%w: unknown parent state %s", ErrUnknownState, parentStateName)
+	}
+
+	if name == "" {
+		return fmt.Errorf("history state name cannot be empty")
+	}
+
+	if _, alreadyAdded := sm.nodes[name]; alreadyAdded {
+		return fmt.Errorf("state already added %s", name)
+	}
+
+	sm.nodes[name] = &Node{
+		Type:        HistoryNode,
+		State:       &State{Name: name},
+		Transitions: map[string][]Transition{},
+		Parent:      parent,
+		History:     historyType,
+	}
+	sm.order = append(sm.order, name)
+
+	return nil
+}
+
+// recordHistory adds a transition to the history log, it must only be called by the goroutine processing the mailbox.
+func (sm *StateMachine) recordHistory(from string, to string, eventName string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	now := sm.clock.Now()
+
+	if sm.historySize > 0 {
+		entry := HistoryEntry{
+			At:    now,
+			From:  from,
+			To:    to,
+			Event: eventName,
+		}
+
+		if from != "" {
+			entry.Duration = now.Sub(sm.enteredAt)
+		}
+
+		if len(sm.history) >= sm.historySize {
+			sm.history = append(sm.history[:0], sm.history[len(sm.history)-sm.historySize+1:]...)
+		}
+
+		sm.history = append(sm.history, entry)
+	}
+
+	sm.enteredAt = now
+	sm.changed = true
+}
+
+// rememberActive keeps the active state of a composite state being left, for its history pseudo-states.
+func (sm *StateMachine) rememberActive(composite *Node, leaf *Node) {
+	sm.mu.Lock()
+	sm.lastActive[composite.State.Name] = leaf.State.Name
+	sm.mu.Unlock()
+}
+
+// resolveHistory returns the state a history pseudo-state stands for: the last active state of its composite state
+// (or its direct sub-state enclosing it, for a shallow history), or the composite state itself if it was never left.
+// The configuration of parallel regions is not remembered, a parallel composite state is entered through the initial
+// sub-states of its regions.
+func (sm *StateMachine) resolveHistory(history *Node) *Node {
+	composite := history.Parent
+
+	if composite.IsParallel() {
+		return composite
+	}
+
+	sm.mu.Lock()
+	last, ok := sm.lastActive[composite.State.Name]
+	sm.mu.Unlock()
+
+	if !ok {
+		return composite
+	}
+
+	leaf := sm.nodes[last]
+
+	if history.History == DeepHistory {
+		return leaf
+	}
+
+	for n := leaf; n != nil; n = n.Parent {
+		if n.Parent == composite {
+			return n
+		}
+	}
+
+	return composite
+}
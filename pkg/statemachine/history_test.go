@@ -0,0 +1,158 @@
+package statemachine
+
+import (
+	"context"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"strings"
+	"testing"
+)
+
+func TestStateMachine_History(t *testing.T) {
+	sm, _ := NewStateMachine(&State{Name: "A"}, context.Background())
+	sm.AddState(&State{Name: "B"})
+	sm.AddTransition("A", event.WithName("Next"), "B")
+	sm.AddTransition("B", event.WithName("Back"), "A")
+
+	sm.Start()
+	sm.TriggerEvent(event.WithName("Next"))
+	sm.TriggerEvent(event.WithName("Back"))
+
+	history := sm.History()
+
+	if len(history) != 3 {
+		t.Fatalf("There should be 3 entries, got %v", history)
+	}
+
+	if history[0].From != "" || history[0].To != "A" || history[0].Event != "" {
+		t.Errorf("First entry should be the start, got %+v", history[0])
+	}
+
+	if history[1].From != "A" || history[1].To != "B" || history[1].Event != "Next" {
+		t.Errorf("Second entry should be A --> B on Next, got %+v", history[1])
+	}
+
+	if history[2].From != "B" || history[2].To != "A" || history[2].Event != "Back" {
+		t.Errorf("Third entry should be B --> A on Back, got %+v", history[2])
+	}
+
+	for i := 1; i < len(history); i++ {
+		if history[i].At.Before(history[i-1].At) || history[i].Duration < 0 {
+			t.Errorf("Entries should be in order, with the time spent in the source state, got %+v", history[i])
+		}
+	}
+}
+
+func TestStateMachine_HistorySize(t *testing.T) {
+	sm, _ := NewStateMachine(&State{Name: "A"}, context.Background())
+	sm.AddState(&State{Name: "B"})
+	sm.AddTransition("A", event.WithName("Toggle"), "B")
+	sm.AddTransition("B", event.WithName("Toggle"), "A")
+	sm.SetHistorySize(2)
+
+	sm.Start()
+
+	for i := 0; i < 5; i++ {
+		sm.TriggerEvent(event.WithName("Toggle"))
+	}
+
+	history := sm.History()
+
+	if len(history) != 2 {
+		t.Fatalf("History should be bounded to 2 entries, got %v", history)
+	}
+
+	if history[1].From != "A" || history[1].To != "B" {
+		t.Errorf("Only the last transitions should be kept, got %+v", history[1])
+	}
+}
+
+const historyGraph = `
+stateDiagram-v2
+    [*] --> Working
+    state Working {
+        [*] --> Editing
+        state Shallow <<history>>
+        state Deep <<deepHistory>>
+        Editing --> Reviewing : Submit
+        state Reviewing {
+            [*] --> Reading
+            Reading --> Commenting : Comment
+        }
+    }
+    Working --> Paused : Pause
+    Paused --> Shallow : ResumeShallow
+    Paused --> Deep : ResumeDeep
+    Paused --> Working : Restart
+`
+
+func TestStateMachine_HistoryStates(t *testing.T) {
+	tests := []struct {
+		event    string
+		expected string
+	}{
+		{event: "ResumeShallow", expected: "Reading"},
+		{event: "ResumeDeep", expected: "Commenting"},
+		{event: "Restart", expected: "Editing"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.event, func(t *testing.T) {
+			sm, _ := NewBuilder().
+				WithInitialState(&State{Name: "Working"}).
+				AddState(&State{Name: "Editing"}).
+				AddState(&State{Name: "Reviewing"}).
+				AddState(&State{Name: "Reading"}).
+				AddState(&State{Name: "Commenting"}).
+				AddState(&State{Name: "Paused"}).
+				FromGraph(historyGraph).
+				Build()
+
+			sm.Start()
+			sm.TriggerEvent(event.WithName("Submit"))
+			sm.TriggerEvent(event.WithName("Comment"))
+			sm.TriggerEvent(event.WithName("Pause"))
+
+			if err := sm.TriggerEvent(event.WithName(tt.event)); err != nil {
+				t.Fatalf("Resuming should not have failed: %v", err)
+			}
+
+			if sm.CurrentState() != tt.expected {
+				t.Errorf("Working State, should have been resumed at %s, got %s", tt.expected, sm.CurrentState())
+			}
+		})
+	}
+}
+
+func TestStateMachine_HistoryState_NeverEntered(t *testing.T) {
+	sm, _ := NewStateMachine(&State{Name: "Idle"}, context.Background())
+	sm.AddState(&State{Name: "Working"})
+	sm.AddSubState("Working", &State{Name: "Editing"})
+	sm.AddSubState("Working", &State{Name: "Reviewing"})
+	sm.AddHistoryState("Working", "H", DeepHistory)
+	sm.AddTransition("Idle", event.WithName("Resume"), "H")
+
+	sm.Start()
+	sm.TriggerEvent(event.WithName("Resume"))
+
+	if sm.CurrentState() != "Editing" {
+		t.Errorf("Working State, should have been entered through its initial sub-state, got %s", sm.CurrentState())
+	}
+}
+
+func TestStateMachine_HistoryStates_Export(t *testing.T) {
+	sm, _ := NewBuilder().
+		WithInitialState(&State{Name: "Working"}).
+		AddState(&State{Name: "Editing"}).
+		AddState(&State{Name: "Reviewing"}).
+		AddState(&State{Name: "Reading"}).
+		AddState(&State{Name: "Commenting"}).
+		AddState(&State{Name: "Paused"}).
+		FromGraph(historyGraph).
+		Build()
+
+	graph := sm.ToMermaid()
+
+	if !strings.Contains(graph, "state Shallow <<history>>") || !strings.Contains(graph, "state Deep <<deepHistory>>") {
+		t.Errorf("History states should have been exported, got %s", graph)
+	}
+}
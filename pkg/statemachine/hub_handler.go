@@ -3,6 +3,7 @@ package statemachine
 import (
 	"context"
 	"github.com/a-inacio/edt-go/pkg/event"
+	"github.com/a-inacio/edt-go/pkg/eventhub"
 )
 
 type stateMachineHubHandler struct {
@@ -12,3 +13,37 @@ type stateMachineHubHandler struct {
 func (h *stateMachineHubHandler) Handler(ctx context.Context, e event.Event) error {
 	return h.sm.TriggerEvent(e)
 }
+
+// subscribeFrom registers the state machine on the hub for the given event, once per event name.
+func (sm *StateMachine) subscribeFrom(hub *eventhub.EventHub, e event.Event) {
+	eventName := event.GetName(e)
+
+	if sm.subscriptions == nil {
+		sm.subscriptions = map[string]event.Event{}
+	}
+
+	if _, subscribed := sm.subscriptions[eventName]; subscribed {
+		return
+	}
+
+	if sm.hubHandler == nil {
+		sm.hubHandler = &stateMachineHubHandler{sm: sm}
+	}
+
+	sm.hub = hub
+	sm.subscriptions[eventName] = e
+	hub.RegisterHandler(e, sm.hubHandler)
+}
+
+// unsubscribe unregisters the state machine from the hub, for all events it subscribed to.
+func (sm *StateMachine) unsubscribe() {
+	if sm.hub == nil || sm.hubHandler == nil {
+		return
+	}
+
+	for _, e := range sm.subscriptions {
+		sm.hub.UnregisterHandler(e, sm.hubHandler)
+	}
+
+	sm.subscriptions = nil
+}
@@ -0,0 +1,132 @@
+package statemachine
+
+import (
+	"fmt"
+	"github.com/a-inacio/edt-go/pkg/event"
+)
+
+// QueuePolicy defines what happens to an event triggered while the queue of pending events is full.
+type QueuePolicy int
+
+const (
+	// QueueBlock makes the caller wait until there is room in the queue (back-pressure).
+	// Hooks must not trigger events under this policy when the queue can be full, they would be waiting on themselves.
+	QueueBlock QueuePolicy = iota
+	// QueueDropNewest discards the event being triggered, ErrEventDropped is returned to the caller.
+	QueueDropNewest
+	// QueueDropOldest discards the oldest pending event to make room for the one being triggered.
+	QueueDropOldest
+)
+
+// SetQueue bounds the number of pending events, a size of zero (the default) means unbounded.
+func (sm *StateMachine) SetQueue(size int, policy QueuePolicy) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.queueSize = size
+	sm.queuePolicy = policy
+}
+
+// TriggerEvent processes an event with run-to-completion semantics.
+// Only one event is processed at a time, when another one is being processed (by another goroutine or because the
+// event is raised from within a hook) the event is queued and processed as soon as the current one completes. In that
+// case nil is returned, a failure processing the queued event is only logged.
+func (sm *StateMachine) TriggerEvent(e event.Event) error {
+	sm.mu.Lock()
+
+	for sm.processing && sm.queuePolicy == QueueBlock && sm.isQueueFull() {
+		sm.cond.Wait()
+	}
+
+	if sm.processing {
+		err := sm.enqueue(e)
+		sm.mu.Unlock()
+		return err
+	}
+
+	sm.processing = true
+	sm.mu.Unlock()
+
+	err := sm.processEvent(e)
+	sm.persist()
+
+	sm.drain()
+
+	return err
+}
+
+func (sm *StateMachine) Start() error {
+	sm.mu.Lock()
+
+	for sm.processing {
+		sm.cond.Wait()
+	}
+
+	if sm.completed {
+		sm.mu.Unlock()
+		return ErrCompleted
+	}
+
+	if sm.current != "" {
+		sm.mu.Unlock()
+		return fmt.Errorf("state machine already runnig at state: %s", sm.current)
+	}
+
+	sm.processing = true
+	sm.mu.Unlock()
+
+	err := sm.start()
+	sm.persist()
+
+	sm.drain()
+
+	return err
+}
+
+func (sm *StateMachine) isQueueFull() bool {
+	return sm.queueSize > 0 && len(sm.queue) >= sm.queueSize
+}
+
+// enqueue adds an event to the queue, applying the queue policy, it must be called while holding the lock.
+func (sm *StateMachine) enqueue(e event.Event) error {
+	if sm.isQueueFull() {
+		switch sm.queuePolicy {
+		case QueueDropNewest:
+			sm.l.Warn("Event dropped, queue is full", "event", event.GetName(e))
+			return fmt.Errorf("%w: %s", ErrEventDropped, event.GetName(e))
+		case QueueDropOldest:
+			sm.l.Warn("Event dropped, queue is full", "event", event.GetName(sm.queue[0]))
+			sm.queue = sm.queue[1:]
+		}
+	}
+
+	sm.queue = append(sm.queue, e)
+
+	return nil
+}
+
+// drain processes the queued events until there are none left, then releases the mailbox.
+func (sm *StateMachine) drain() {
+	for {
+		sm.mu.Lock()
+
+		if len(sm.queue) == 0 {
+			sm.processing = false
+			sm.cond.Broadcast()
+			sm.mu.Unlock()
+			return
+		}
+
+		e := sm.queue[0]
+		sm.queue = sm.queue[1:]
+		sm.cond.Broadcast()
+
+		sm.mu.Unlock()
+
+		if err := sm.processEvent(e); err != nil {
+			sm.l.Warn("Queued event failed", "event", event.GetName(e), "reason", err)
+		}
+
+		sm.persist()
+	}
+}
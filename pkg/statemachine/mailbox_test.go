@@ -0,0 +1,171 @@
+package statemachine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/a-inacio/edt-go/pkg/eventhub"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStateMachine_TriggerEvent_Concurrently(t *testing.T) {
+	type Toggle struct {
+	}
+
+	var inside int32
+	overlapped := false
+	entered := 0
+
+	hook := func(ctx context.Context, trigger Trigger) error {
+		if atomic.AddInt32(&inside, 1) > 1 {
+			overlapped = true
+		}
+
+		time.Sleep(time.Millisecond)
+		entered++
+
+		atomic.AddInt32(&inside, -1)
+		return nil
+	}
+
+	hub := eventhub.NewEventHub(nil)
+
+	sm, _ := NewBuilder().
+		WithInitialState(&State{Name: "Off", OnEnter: hook}).
+		AddState(&State{Name: "On", OnEnter: hook}).
+		AddTransition("Off", Toggle{}, "On").
+		AddTransition("On", Toggle{}, "Off").
+		SubscribeFrom(hub).
+		Build()
+
+	sm.Start()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hub.Publish(Toggle{}, nil).Wait()
+		}()
+	}
+
+	wg.Wait()
+
+	// Events may still be processed by the last goroutine holding the mailbox, starting again waits for it
+	sm.Start()
+
+	if overlapped {
+		t.Error("Hooks should never run concurrently")
+	}
+
+	if entered != 51 {
+		t.Errorf("Every event should have been processed, expected 51 entries got %d", entered)
+	}
+
+	if sm.CurrentState() != "Off" {
+		t.Errorf("An even number of toggles should end in Off, got %s", sm.CurrentState())
+	}
+}
+
+func TestStateMachine_TriggerEvent_FromHook(t *testing.T) {
+	type GoToB struct {
+	}
+
+	type GoToC struct {
+	}
+
+	var calls []string
+
+	var sm *StateMachine
+	sm, _ = NewStateMachine(&State{Name: "A"}, context.Background())
+
+	sm.AddState(&State{
+		Name: "B",
+		OnEnter: func(ctx context.Context, trigger Trigger) error {
+			calls = append(calls, "enter B")
+
+			if err := sm.TriggerEvent(GoToC{}); err != nil {
+				t.Errorf("Raising an event from a hook should queue it, got %v", err)
+			}
+
+			calls = append(calls, "raised GoToC")
+			return nil
+		},
+		OnAfter: func(ctx context.Context, trigger Trigger) error {
+			calls = append(calls, "after B")
+			return nil
+		},
+	})
+
+	sm.AddState(&State{
+		Name: "C",
+		OnEnter: func(ctx context.Context, trigger Trigger) error {
+			calls = append(calls, "enter C")
+			return nil
+		},
+	})
+
+	sm.AddTransition("A", GoToB{}, "B")
+	sm.AddTransition("B", GoToC{}, "C")
+
+	sm.Start()
+
+	if err := sm.TriggerEvent(GoToB{}); err != nil {
+		t.Errorf("A State, should be possible to transition to B: %v", err)
+	}
+
+	expected := []string{"enter B", "raised GoToC", "after B", "enter C"}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Errorf("The raised event should be processed after B is entered, expected %v, got %v", expected, calls)
+	}
+
+	if sm.CurrentState() != "C" {
+		t.Errorf("should have ended in C, got %s", sm.CurrentState())
+	}
+}
+
+func TestStateMachine_TriggerEvent_BoundedQueue(t *testing.T) {
+	type Tick struct {
+	}
+
+	ticks := 0
+	var dropped []error
+
+	var sm *StateMachine
+	sm, _ = NewStateMachine(&State{Name: "A"}, context.Background())
+
+	sm.AddState(&State{
+		Name: "B",
+		OnEnter: func(ctx context.Context, trigger Trigger) error {
+			ticks++
+
+			if ticks == 1 {
+				for i := 0; i < 5; i++ {
+					if err := sm.TriggerEvent(Tick{}); err != nil {
+						dropped = append(dropped, err)
+					}
+				}
+			}
+
+			return nil
+		},
+	})
+
+	sm.AddTransition("A", Tick{}, "B")
+	sm.AddTransition("B", Tick{}, "B")
+
+	sm.SetQueue(2, QueueDropNewest)
+	sm.Start()
+	sm.TriggerEvent(Tick{})
+
+	if ticks != 3 {
+		t.Errorf("Only the first event and two queued should have been processed, got %d", ticks)
+	}
+
+	if len(dropped) != 3 || !errors.Is(dropped[0], ErrEventDropped) {
+		t.Errorf("Three events should have been dropped, got %v", dropped)
+	}
+}
@@ -0,0 +1,260 @@
+package statemachine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"github.com/a-inacio/edt-go/pkg/eventhub"
+	"sync"
+)
+
+// ErrNoCorrelationKey is returned when the instance an event is meant for cannot be told.
+var ErrNoCorrelationKey = errors.New("no correlation key")
+
+// KeyFunc extracts the correlation key of an event, the id of the state machine instance it is meant for, ok is false
+// when the event has none.
+type KeyFunc func(e event.Event) (key string, ok bool)
+
+// completedKeys is the number of completed instances a Manager remembers, events for them fail with ErrCompleted.
+const completedKeys = 1024
+
+type instanceIDKey struct{}
+
+// InstanceID returns the id of the state machine instance a context belongs to, for hooks and actions of instances
+// created by a Manager.
+func InstanceID(ctx context.Context) (string, bool) {
+	if ctx == nil {
+		return "", false
+	}
+
+	id, ok := ctx.Value(instanceIDKey{}).(string)
+	return id, ok
+}
+
+// Manager runs many state machines of the same Definition, one per correlation key (e.g. one per order).
+// Events are routed to the instance their key designates, instances are created (and started) on their first event
+// and evicted once completed, the keys of the last completed ones are remembered so late events fail with ErrCompleted
+// rather than creating a new instance. Subscribing to a hub registers a single handler per event, whatever the number of
+// instances.
+// When the definition has a store, instances are restored from their last snapshot before being started.
+type Manager struct {
+	mu         sync.Mutex
+	definition *Definition
+	key        KeyFunc
+	context    context.Context
+	hub        *eventhub.EventHub
+	handler    *managerHubHandler
+	instances  map[string]*managedInstance
+	completed  map[string]struct{}
+	// the completed keys, oldest first, to forget the oldest once there are too many
+	completedOrder []string
+}
+
+// managedInstance is an instance of a Manager, registered before being started or restored, which happens once and
+// outside the lock of the Manager, as it runs hooks and publishes on the hub.
+type managedInstance struct {
+	sm   *StateMachine
+	once sync.Once
+	err  error
+}
+
+type managerHubHandler struct {
+	m *Manager
+}
+
+func (h *managerHubHandler) Handler(ctx context.Context, e event.Event) error {
+	return h.m.TriggerEvent(e)
+}
+
+func NewManager(definition *Definition, key KeyFunc, ctx context.Context) *Manager {
+	return &Manager{
+		definition: definition,
+		key:        key,
+		context:    ctx,
+		instances:  map[string]*managedInstance{},
+		completed:  map[string]struct{}{},
+	}
+}
+
+// SubscribeFrom routes the events of the hub the state machine has transitions for to the instances, which also
+// publish their lifecycle events on it.
+func (m *Manager) SubscribeFrom(hub *eventhub.EventHub) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.handler != nil {
+		return
+	}
+
+	m.hub = hub
+	m.handler = &managerHubHandler{m: m}
+
+	for _, e := range m.definition.hubEvents() {
+		hub.RegisterHandler(e, m.handler)
+	}
+}
+
+// Close unsubscribes from the hub, instances are left as they are.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.handler == nil {
+		return
+	}
+
+	for _, e := range m.definition.hubEvents() {
+		m.hub.UnregisterHandler(e, m.handler)
+	}
+
+	m.handler = nil
+}
+
+// TriggerEvent triggers the event on the instance designated by its correlation key, creating it if needed.
+func (m *Manager) TriggerEvent(e event.Event) error {
+	key, ok := m.key(e)
+
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoCorrelationKey, event.GetName(e))
+	}
+
+	sm, err := m.Instance(key)
+
+	if err != nil {
+		return err
+	}
+
+	return sm.TriggerEvent(e)
+}
+
+// Instance returns the running instance for a key, creating and starting it if needed, or an error wrapping
+// ErrCompleted when the instance for the key already completed.
+func (m *Manager) Instance(key string) (*StateMachine, error) {
+	m.mu.Lock()
+
+	if _, done := m.completed[key]; done {
+		m.mu.Unlock()
+		return nil, fmt.Errorf("%w: instance %s", ErrCompleted, key)
+	}
+
+	instance, ok := m.instances[key]
+	if !ok {
+		instance = m.newInstance(key)
+		m.instances[key] = instance
+	}
+
+	m.mu.Unlock()
+
+	instance.once.Do(func() {
+		instance.err = m.init(key, instance.sm)
+	})
+
+	if instance.err != nil {
+		m.evict(key, instance)
+		return nil, instance.err
+	}
+
+	// A completed instance is not kept (e.g. restored from a final snapshot), events for it fail with ErrCompleted
+	if instance.sm.IsCompleted() {
+		m.complete(key, instance)
+	}
+
+	return instance.sm, nil
+}
+
+// newInstance creates the instance for a key, evicted once it completes, it must be called while holding the lock.
+func (m *Manager) newInstance(key string) *managedInstance {
+	ctx := m.context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	instance := &managedInstance{sm: m.definition.New(key, context.WithValue(ctx, instanceIDKey{}, key))}
+	instance.sm.PublishTo(m.hub)
+	instance.sm.onDone = func() {
+		m.complete(key, instance)
+	}
+
+	return instance
+}
+
+// init restores the instance from its last snapshot, when the definition has a store, or starts it.
+func (m *Manager) init(key string, sm *StateMachine) error {
+	if sm.store != nil {
+		ctx := m.context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		err := sm.RestoreFrom(ctx, sm.store, key)
+
+		if err == nil {
+			return nil
+		}
+
+		if !errors.Is(err, ErrSnapshotNotFound) {
+			return fmt.Errorf("restoring instance %s: %w", key, err)
+		}
+	}
+
+	if err := sm.Start(); err != nil {
+		return fmt.Errorf("starting instance %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// Lookup returns the running instance for a key, without creating it.
+func (m *Manager) Lookup(key string) (*StateMachine, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	instance, ok := m.instances[key]
+	if !ok {
+		return nil, false
+	}
+
+	return instance.sm, true
+}
+
+// Len returns the number of running instances.
+func (m *Manager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.instances)
+}
+
+func (m *Manager) evict(key string, instance *managedInstance) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.instances[key] == instance {
+		delete(m.instances, key)
+	}
+}
+
+// complete evicts a completed instance and remembers its key, forgetting the oldest completed key past completedKeys.
+func (m *Manager) complete(key string, instance *managedInstance) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.instances[key] != instance {
+		return
+	}
+
+	delete(m.instances, key)
+
+	if _, done := m.completed[key]; done {
+		return
+	}
+
+	m.completed[key] = struct{}{}
+	m.completedOrder = append(m.completedOrder, key)
+
+	if len(m.completedOrder) > completedKeys {
+		delete(m.completed, m.completedOrder[0])
+		m.completedOrder = m.completedOrder[1:]
+	}
+}
@@ -0,0 +1,194 @@
+package statemachine
+
+import (
+	"context"
+	"errors"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"github.com/a-inacio/edt-go/pkg/eventhub"
+	"sync"
+	"testing"
+	"time"
+)
+
+func orderKey(e event.Event) (string, bool) {
+	named, ok := e.(*event.GenericNamedEvent)
+	if !ok {
+		return "", false
+	}
+
+	key, ok := named.Values["order"].(string)
+	return key, ok
+}
+
+func TestManager_TriggerEvent(t *testing.T) {
+	var paid sync.Map
+
+	definition, _ := NewBuilder().
+		WithInitialState(&State{Name: "Created"}).
+		AddState(&State{Name: "Paid", OnEnter: func(ctx context.Context, trigger Trigger) error {
+			id, _ := InstanceID(ctx)
+			paid.Store(id, true)
+			return nil
+		}}).
+		AddState(&State{Name: "Shipped"}).
+		FromGraph(orderGraph).
+		Compile()
+
+	m := NewManager(definition, orderKey, context.Background())
+
+	if err := m.TriggerEvent(event.WithNameAndKeyValues("Pay", "order", "1")); err != nil {
+		t.Fatalf("Pay should have been handled, got %v", err)
+	}
+
+	m.TriggerEvent(event.WithNameAndKeyValues("Pay", "order", "2"))
+
+	if m.Len() != 2 {
+		t.Errorf("An instance per order should have been created, got %d", m.Len())
+	}
+
+	if _, ok := paid.Load("2"); !ok {
+		t.Error("Hooks should know the instance they run for")
+	}
+
+	first, _ := m.Lookup("1")
+
+	if first.CurrentState() != "Paid" || first.ID() != "1" {
+		t.Errorf("Paid State, should be active for order 1, got %s", first.CurrentState())
+	}
+
+	if err := first.AddState(&State{Name: "Cancelled"}); !errors.Is(err, ErrSharedDefinition) {
+		t.Errorf("The states of an instance should not be changeable, got %v", err)
+	}
+
+	m.TriggerEvent(event.WithNameAndKeyValues("Ship", "order", "1"))
+
+	<-first.Done()
+
+	deadline := time.Now().Add(time.Second)
+	for m.Len() != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, ok := m.Lookup("1"); ok {
+		t.Error("A completed instance should have been evicted")
+	}
+
+	if err := m.TriggerEvent(event.WithNameAndKeyValues("Pay", "order", "1")); !errors.Is(err, ErrCompleted) {
+		t.Errorf("A late event for a completed instance should have been rejected, got %v", err)
+	}
+
+	if m.Len() != 1 {
+		t.Errorf("A late event should not have created a new instance, got %d", m.Len())
+	}
+
+	if err := m.TriggerEvent(event.WithName("Pay")); !errors.Is(err, ErrNoCorrelationKey) {
+		t.Errorf("An event without key should have been rejected, got %v", err)
+	}
+}
+
+func TestManager_SubscribeFrom(t *testing.T) {
+	hub := eventhub.NewEventHub(nil)
+
+	definition, _ := NewBuilder().
+		WithInitialState(&State{Name: "Created"}).
+		AddState(&State{Name: "Paid"}).
+		AddState(&State{Name: "Shipped"}).
+		FromGraph(orderGraph).
+		Compile()
+
+	m := NewManager(definition, orderKey, context.Background())
+	m.SubscribeFrom(hub)
+
+	entered := listen[StateEntered](hub)
+
+	for _, order := range []string{"1", "2", "3"} {
+		hub.Publish(event.WithNameAndKeyValues("Pay", "order", order), context.Background()).Wait()
+	}
+
+	if m.Len() != 3 {
+		t.Errorf("An instance per order should have been created, got %d", m.Len())
+	}
+
+	seen := map[string]bool{}
+	for i := 0; i < 6; i++ {
+		e := receive(t, entered)
+		seen[e.Machine+"/"+e.State] = true
+	}
+
+	if !seen["3/Created"] || !seen["3/Paid"] {
+		t.Errorf("Instances should publish their lifecycle events, got %v", seen)
+	}
+
+	m.Close()
+	hub.Publish(event.WithNameAndKeyValues("Pay", "order", "4"), context.Background()).Wait()
+
+	if m.Len() != 3 {
+		t.Errorf("Events should not be routed once closed, got %d instances", m.Len())
+	}
+}
+
+func TestManager_RestoresFromStore(t *testing.T) {
+	store, _ := NewFileStore(t.TempDir())
+
+	definition, _ := NewBuilder().
+		WithInitialState(&State{Name: "Created"}).
+		AddState(&State{Name: "Paid"}).
+		AddState(&State{Name: "Shipped"}).
+		WithStore(store).
+		FromGraph(`
+			[*] --> Created
+			Created --> Paid: Pay
+			Paid --> Shipped: Ship
+		`).
+		Compile()
+
+	NewManager(definition, orderKey, context.Background()).TriggerEvent(event.WithNameAndKeyValues("Pay", "order", "1"))
+
+	m := NewManager(definition, orderKey, context.Background())
+
+	if err := m.TriggerEvent(event.WithNameAndKeyValues("Ship", "order", "1")); err != nil {
+		t.Fatalf("The instance should have been restored, got %v", err)
+	}
+
+	if sm, _ := m.Lookup("1"); sm.CurrentState() != "Shipped" {
+		t.Errorf("Shipped State, should be active, got %s", sm.CurrentState())
+	}
+}
+
+func TestManager_SynchronousHub(t *testing.T) {
+	hub := eventhub.NewEventHub(&eventhub.Config{Delivery: eventhub.DeliverySynchronous})
+
+	definition, _ := NewBuilder().
+		WithInitialState(&State{Name: "Created"}).
+		AddState(&State{Name: "Paid"}).
+		AddState(&State{Name: "Shipped"}).
+		FromGraph(orderGraph).
+		Compile()
+
+	m := NewManager(definition, orderKey, context.Background())
+	m.SubscribeFrom(hub)
+
+	// Lifecycle subscribers are called while the instance is being started
+	hub.RegisterHandler(StateEntered{}, eventhub.HandlerFunc(func(ctx context.Context, e event.Event) error {
+		m.Lookup("1")
+		m.Len()
+		return nil
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		hub.Publish(event.WithNameAndKeyValues("Pay", "order", "1"), context.Background())
+		hub.Publish(event.WithNameAndKeyValues("Ship", "order", "1"), context.Background())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Calling the Manager from a lifecycle subscriber should not have deadlocked")
+	}
+
+	if _, ok := m.Lookup("1"); ok {
+		t.Error("A completed instance should have been evicted as it completed")
+	}
+}
@@ -0,0 +1,197 @@
+package statemachine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrVersionMismatch is returned when restoring a snapshot taken from another version of the state machine definition.
+var ErrVersionMismatch = errors.New("snapshot version does not match the state machine definition")
+
+// Snapshot is the persistable state of a state machine, it can be serialised to JSON.
+type Snapshot struct {
+	// ID identifies the state machine instance, e.g. the order being processed.
+	ID string `json:"id,omitempty"`
+	// Version is the version of the state machine definition the snapshot was taken from.
+	Version string `json:"version,omitempty"`
+	// Current is the innermost active state, empty if the state machine was not started.
+	Current string `json:"current"`
+	// Active holds the innermost active states of all regions, when inside parallel regions.
+	Active    []string `json:"active,omitempty"`
+	Completed bool     `json:"completed,omitempty"`
+	// History holds the last transitions taken, oldest first.
+	History []HistoryEntry `json:"history,omitempty"`
+	// LastActive maps a composite state to its last active state, what its history pseudo-states resume.
+	LastActive map[string]string `json:"lastActive,omitempty"`
+	TakenAt    time.Time         `json:"takenAt"`
+}
+
+// SetID defines the identifier of the state machine instance, used as key when persisting it.
+func (sm *StateMachine) SetID(id string) {
+	sm.id = id
+}
+
+// ID returns the identifier of the state machine instance.
+func (sm *StateMachine) ID() string {
+	return sm.id
+}
+
+// SetVersion defines the version of the state machine definition, a snapshot can only be restored by the same version.
+func (sm *StateMachine) SetVersion(version string) {
+	sm.version = version
+}
+
+// SetStore makes the state machine save a snapshot in the store every time its state changes.
+// Failures saving are logged, the state machine keeps running.
+func (sm *StateMachine) SetStore(store Store) {
+	sm.store = store
+}
+
+// Snapshot captures the current state of the state machine.
+func (sm *StateMachine) Snapshot() *Snapshot {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	lastActive := map[string]string{}
+	for composite, state := range sm.lastActive {
+		lastActive[composite] = state
+	}
+
+	var active []string
+	if len(sm.leaves) > 1 {
+		for _, leaf := range sm.leaves {
+			active = append(active, leaf.State.Name)
+		}
+	}
+
+	return &Snapshot{
+		Active:     active,
+		ID:         sm.id,
+		Version:    sm.version,
+		Current:    sm.current,
+		Completed:  sm.completed,
+		History:    append([]HistoryEntry{}, sm.history...),
+		LastActive: lastActive,
+		TakenAt:    sm.clock.Now(),
+	}
+}
+
+// Restore resumes the state machine at the state saved in the snapshot, instead of starting it.
+// No hook is called: the states were already entered when the snapshot was taken.
+func (sm *StateMachine) Restore(snapshot *Snapshot) error {
+	if snapshot.Version != sm.version {
+		return fmt.Errorf("%w: expected %q, got %q", ErrVersionMismatch, sm.version, snapshot.Version)
+	}
+
+	active := snapshot.Active
+	if len(active) == 0 && snapshot.Current != "" {
+		active = []string{snapshot.Current}
+	}
+
+	var leaves []*Node
+
+	for _, name := range active {
+		node, ok := sm.nodes[name]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownState, name)
+		}
+
+		leaves = append(leaves, node)
+	}
+
+	for composite, state := range snapshot.LastActive {
+		if sm.nodes[composite] == nil || sm.nodes[state] == nil {
+			return fmt.Errorf("%w: %s or %s", ErrUnknownState, composite, state)
+		}
+	}
+
+	sm.mu.Lock()
+
+	for sm.processing {
+		sm.cond.Wait()
+	}
+
+	if sm.current != "" || sm.completed {
+		defer sm.mu.Unlock()
+		return fmt.Errorf("state machine already runnig at state: %s", sm.current)
+	}
+
+	if snapshot.ID != "" {
+		sm.id = snapshot.ID
+	}
+
+	sm.leaves = leaves
+	sm.settleLocked()
+	sm.history = append([]HistoryEntry{}, snapshot.History...)
+	sm.enteredAt = sm.clock.Now()
+
+	if len(sm.history) > sm.historySize {
+		sm.history = sm.history[len(sm.history)-sm.historySize:]
+	}
+
+	for composite, state := range snapshot.LastActive {
+		sm.lastActive[composite] = state
+	}
+
+	if snapshot.Completed {
+		sm.completed = true
+		close(sm.done)
+	}
+
+	sm.mu.Unlock()
+
+	// A completed state machine no longer listens to its hub, as when completing
+	if snapshot.Completed {
+		sm.unsubscribe()
+	}
+
+	// Timers and activities restart from the moment of restoring
+	sm.syncTimers()
+	sm.syncActivities()
+
+	return nil
+}
+
+// RestoreFrom loads the snapshot saved under the given id and restores it, see Restore.
+func (sm *StateMachine) RestoreFrom(ctx context.Context, store Store, id string) error {
+	snapshot, err := store.Load(ctx, id)
+
+	if err != nil {
+		return err
+	}
+
+	return sm.Restore(snapshot)
+}
+
+// SaveTo saves a snapshot of the state machine in the store, under its id.
+func (sm *StateMachine) SaveTo(ctx context.Context, store Store) error {
+	if sm.id == "" {
+		return errors.New("state machine has no id")
+	}
+
+	return store.Save(ctx, sm.Snapshot())
+}
+
+// persist saves a snapshot in the store when the state changed, it must only be called by the goroutine processing the
+// mailbox.
+func (sm *StateMachine) persist() {
+	sm.mu.Lock()
+	changed := sm.changed
+	sm.changed = false
+	sm.mu.Unlock()
+
+	if sm.store == nil || !changed {
+		return
+	}
+
+	ctx := sm.context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if err := sm.SaveTo(ctx, sm.store); err != nil {
+		sm.l.Warn("Failed saving the state machine", "id", sm.id, "reason", err)
+	}
+}
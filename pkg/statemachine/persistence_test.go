@@ -0,0 +1,185 @@
+package statemachine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"github.com/a-inacio/edt-go/pkg/eventhub"
+	"testing"
+)
+
+const orderGraph = `
+stateDiagram-v2
+    [*] --> Created
+    Created --> Paid : Pay
+    Paid --> Shipped : Ship
+    Shipped --> [*]
+`
+
+func TestStateMachine_Snapshot_Restore(t *testing.T) {
+	sm, _ := NewBuilder().
+		WithInitialState(&State{Name: "Created"}).
+		AddState(&State{Name: "Paid"}).
+		AddState(&State{Name: "Shipped"}).
+		FromGraph(orderGraph).
+		WithID("order-1").
+		WithVersion("v1").
+		Build()
+
+	sm.Start()
+	sm.TriggerEvent(event.WithName("Pay"))
+
+	data, err := json.Marshal(sm.Snapshot())
+
+	if err != nil {
+		t.Fatalf("Snapshot should be serialisable: %v", err)
+	}
+
+	snapshot := &Snapshot{}
+	json.Unmarshal(data, snapshot)
+
+	var restoredEntered []string
+	hook := func(ctx context.Context, trigger Trigger) error {
+		restoredEntered = append(restoredEntered, trigger.ToState.Name)
+		return nil
+	}
+
+	restored, _ := NewBuilder().
+		WithInitialState(&State{Name: "Created", OnEnter: hook}).
+		AddState(&State{Name: "Paid", OnEnter: hook}).
+		AddState(&State{Name: "Shipped", OnEnter: hook}).
+		FromGraph(orderGraph).
+		WithID("order-1").
+		WithVersion("v1").
+		Build()
+
+	if err = restored.Restore(snapshot); err != nil {
+		t.Fatalf("Restore should not have failed: %v", err)
+	}
+
+	if restored.CurrentState() != "Paid" {
+		t.Errorf("Restored state machine should be at Paid, got %s", restored.CurrentState())
+	}
+
+	if len(restoredEntered) != 0 {
+		t.Errorf("Restore should not run entry hooks, got %v", restoredEntered)
+	}
+
+	if got := restored.History(); len(got) != 2 || got[0].To != "Created" || got[1].To != "Paid" {
+		t.Errorf("History should have been restored, got %v", got)
+	}
+
+	if err = restored.TriggerEvent(event.WithName("Ship")); err != nil {
+		t.Errorf("Restored state machine should process events: %v", err)
+	}
+
+	if !restored.IsCompleted() {
+		t.Error("Restored state machine should have completed")
+	}
+}
+
+func TestStateMachine_Restore_Completed(t *testing.T) {
+	hub := eventhub.NewEventHub(nil)
+
+	sm, _ := NewBuilder().
+		WithInitialState(&State{Name: "Created"}).
+		AddState(&State{Name: "Paid"}).
+		FromGraph(`
+stateDiagram-v2
+    [*] --> Created
+    Created --> Paid : Pay
+`).
+		SubscribeFrom(hub).
+		Build()
+
+	if err := sm.Restore(&Snapshot{Current: "Paid", Completed: true}); err != nil {
+		t.Fatalf("Restore should not have failed: %v", err)
+	}
+
+	if err := hub.PublishAndCollect(event.WithName("Pay"), nil); err != nil {
+		t.Errorf("A restored completed state machine should have unsubscribed from its hub, got %v", err)
+	}
+}
+
+func TestStateMachine_Restore_Errors(t *testing.T) {
+	sm, _ := NewBuilder().
+		WithInitialState(&State{Name: "Created"}).
+		AddState(&State{Name: "Paid"}).
+		AddState(&State{Name: "Shipped"}).
+		FromGraph(orderGraph).
+		WithID("order-1").
+		WithVersion("v2").
+		Build()
+
+	if err := sm.Restore(&Snapshot{Version: "v1", Current: "Paid"}); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("Restoring another version should fail with ErrVersionMismatch, got %v", err)
+	}
+
+	if err := sm.Restore(&Snapshot{Version: "v2", Current: "Unknown"}); !errors.Is(err, ErrUnknownState) {
+		t.Errorf("Restoring an unknown state should fail with ErrUnknownState, got %v", err)
+	}
+
+	sm.Start()
+
+	if err := sm.Restore(&Snapshot{Version: "v2", Current: "Paid"}); err == nil {
+		t.Error("Restoring a running state machine should fail")
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+
+	if err != nil {
+		t.Fatalf("Creating the store should not have failed: %v", err)
+	}
+
+	sm, _ := NewBuilder().
+		WithInitialState(&State{Name: "Created"}).
+		AddState(&State{Name: "Paid"}).
+		AddState(&State{Name: "Shipped"}).
+		FromGraph(orderGraph).
+		WithID("order-1").
+		WithVersion("v1").
+		WithStore(store).
+		Build()
+
+	sm.Start()
+	sm.TriggerEvent(event.WithName("Pay"))
+
+	snapshot, err := store.Load(context.Background(), "order-1")
+
+	if err != nil {
+		t.Fatalf("The state machine should have been saved: %v", err)
+	}
+
+	if snapshot.Current != "Paid" || snapshot.Version != "v1" {
+		t.Errorf("Saved snapshot should be at Paid on v1, got %s on %s", snapshot.Current, snapshot.Version)
+	}
+
+	restored, _ := NewBuilder().
+		WithInitialState(&State{Name: "Created"}).
+		AddState(&State{Name: "Paid"}).
+		AddState(&State{Name: "Shipped"}).
+		FromGraph(orderGraph).
+		WithID("order-1").
+		WithVersion("v1").
+		WithStore(store).
+		Build()
+
+	if err = restored.RestoreFrom(context.Background(), store, "order-1"); err != nil {
+		t.Fatalf("RestoreFrom should not have failed: %v", err)
+	}
+
+	if restored.CurrentState() != "Paid" {
+		t.Errorf("Restored state machine should be at Paid, got %s", restored.CurrentState())
+	}
+
+	if err = store.Delete(context.Background(), "order-1"); err != nil {
+		t.Errorf("Delete should not have failed: %v", err)
+	}
+
+	if _, err = store.Load(context.Background(), "order-1"); !errors.Is(err, ErrSnapshotNotFound) {
+		t.Errorf("Loading a deleted snapshot should fail with ErrSnapshotNotFound, got %v", err)
+	}
+}
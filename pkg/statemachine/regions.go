@@ -0,0 +1,252 @@
+package statemachine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// IsParallel tells if the node is a composite state with several concurrent (orthogonal) regions, all of them being
+// active at the same time.
+func (n *Node) IsParallel() bool {
+	return len(n.RegionInitials) > 1
+}
+
+// depth is the number of states enclosing the node.
+func (n *Node) depth() int {
+	d := 0
+	for p := n.Parent; p != nil; p = p.Parent {
+		d++
+	}
+
+	return d
+}
+
+// SubFinalStateName returns the name of the final state of a region of a composite state, see AddSubFinalState.
+func SubFinalStateName(parentStateName string, region int) string {
+	return fmt.Sprintf("%s/%d/%s", parentStateName, region, FinalState)
+}
+
+// AddSubStateInRegion adds a state nested inside a concurrent region of a composite state.
+// Regions are numbered from zero, in order: a region is created by adding its first sub-state, which becomes its
+// initial sub-state unless changed by SetInitialSubState. AddSubState adds to the first region.
+func (sm *StateMachine) AddSubStateInRegion(parentStateName string, region int, state *State) error {
+	parent, ok := sm.nodes[parentStateName]
+	if !ok {
+		return fmt.Errorf("%w: unknown parent state %s", ErrUnknownState, parentStateName)
+	}
+
+	if region < 0 || region > len(parent.RegionInitials) {
+		return fmt.Errorf("invalid region %d of %s, regions must be added in order", region, parentStateName)
+	}
+
+	return sm.addNode(state, parent, region)
+}
+
+// AddSubFinalState adds the final state of a region of a composite state and returns its name.
+// Once the final states of all its regions are active, the completion transitions of the composite state are taken.
+func (sm *StateMachine) AddSubFinalState(parentStateName string, region int) (string, error) {
+	if sm.shared {
+		return "", ErrSharedDefinition
+	}
+
+	parent, ok := sm.nodes[parentStateName]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown parent state %s", ErrUnknownState, parentStateName)
+	}
+
+	if region < 0 || region >= len(parent.RegionInitials) {
+		return "", fmt.Errorf("invalid region %d of %s", region, parentStateName)
+	}
+
+	name := SubFinalStateName(parentStateName, region)
+
+	if _, alreadyAdded := sm.nodes[name]; alreadyAdded {
+		return name, nil
+	}
+
+	sm.nodes[name] = &Node{
+		Type:        TerminalNode,
+		State:       &State{Name: name},
+		Transitions: map[string][]Transition{},
+		Parent:      parent,
+		Region:      region,
+		index:       len(sm.order),
+	}
+	sm.order = append(sm.order, name)
+
+	return name, nil
+}
+
+// ActiveStates returns the names of the innermost active states, one per active region, in region order.
+func (sm *StateMachine) ActiveStates() []string {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	names := make([]string, len(sm.leaves))
+	for i, n := range sm.leaves {
+		names[i] = n.State.Name
+	}
+
+	return names
+}
+
+// activeLeaves returns a copy of the innermost active states.
+func (sm *StateMachine) activeLeaves() []*Node {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	return append([]*Node{}, sm.leaves...)
+}
+
+// setLeaves replaces the innermost active states, the current state becomes the first of them.
+func (sm *StateMachine) setLeaves(leaves []*Node) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.leaves = leaves
+	sm.settleLocked()
+}
+
+// settle makes the current state the first innermost active state, once a transition completed.
+func (sm *StateMachine) settle() {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.settleLocked()
+}
+
+func (sm *StateMachine) settleLocked() {
+	if len(sm.leaves) == 0 {
+		sm.current = ""
+		return
+	}
+
+	sm.current = sm.leaves[0].State.Name
+}
+
+func (sm *StateMachine) addLeaf(node *Node) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.leaves = append(sm.leaves, node)
+
+	sort.SliceStable(sm.leaves, func(i, j int) bool {
+		return leafLess(sm.leaves[i], sm.leaves[j])
+	})
+}
+
+// leafLess orders the innermost active states by region, then by declaration order.
+func leafLess(a *Node, b *Node) bool {
+	for x := a; x != nil; x = x.Parent {
+		for y := b; y != nil; y = y.Parent {
+			if x.Parent == y.Parent && x != y && x.Region != y.Region {
+				return x.Region < y.Region
+			}
+		}
+	}
+
+	return a.index < b.index
+}
+
+func (sm *StateMachine) removeLeaf(node *Node) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	for i, n := range sm.leaves {
+		if n == node {
+			sm.leaves = append(sm.leaves[:i:i], sm.leaves[i+1:]...)
+			return
+		}
+	}
+}
+
+func (sm *StateMachine) isActiveLeaf(node *Node) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	for _, n := range sm.leaves {
+		if n == node {
+			return true
+		}
+	}
+
+	return false
+}
+
+// activeLeafUnder returns the first innermost active state that is the node itself or nested inside it.
+func (sm *StateMachine) activeLeafUnder(node *Node) *Node {
+	for _, leaf := range sm.activeLeaves() {
+		if leaf == node || leaf.isDescendantOf(node) {
+			return leaf
+		}
+	}
+
+	return nil
+}
+
+// activeNames describes the active states, for error messages.
+func (sm *StateMachine) activeNames() string {
+	return strings.Join(sm.ActiveStates(), ", ")
+}
+
+type exitStep struct {
+	node *Node
+	// leaf is the innermost active state the node was exited from.
+	leaf *Node
+}
+
+// exitSet returns the active states a transition from the source state leaves, innermost first: all the active
+// states nested inside the outermost state being left, which is the source or its ancestor right below the domain.
+func (sm *StateMachine) exitSet(source *Node, domain *Node) []exitStep {
+	top := source
+	for top.Parent != domain {
+		top = top.Parent
+	}
+
+	var steps []exitStep
+	seen := map[*Node]bool{}
+
+	for _, leaf := range sm.activeLeaves() {
+		if leaf != top && !leaf.isDescendantOf(top) {
+			continue
+		}
+
+		for n := leaf; n != domain; n = n.Parent {
+			if !seen[n] {
+				seen[n] = true
+				steps = append(steps, exitStep{node: n, leaf: leaf})
+			}
+		}
+	}
+
+	sort.SliceStable(steps, func(i, j int) bool {
+		return steps[i].node.depth() > steps[j].node.depth()
+	})
+
+	return steps
+}
+
+// dropLeaves forgets, without running any hook, the active states a transition from the source state would leave.
+func (sm *StateMachine) dropLeaves(source *Node, domain *Node) {
+	if source == nil {
+		return
+	}
+
+	for _, step := range sm.exitSet(source, domain) {
+		sm.removeLeaf(step.node)
+	}
+}
+
+// isCompleted tells if the final states of all the regions of a composite state are active.
+func (sm *StateMachine) isCompleted(composite *Node) bool {
+	completed := map[int]bool{}
+
+	for _, leaf := range sm.activeLeaves() {
+		if leaf.Type == TerminalNode && leaf.Parent == composite {
+			completed[leaf.Region] = true
+		}
+	}
+
+	return len(completed) == len(composite.RegionInitials)
+}
@@ -0,0 +1,190 @@
+package statemachine
+
+import (
+	"context"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const devicesGraph = `
+stateDiagram-v2
+    [*] --> Device
+    state Device {
+        [*] --> Disconnected
+        Disconnected --> Connected : Connect
+        Connected --> Disconnected : Disconnect
+        Connected --> [*] : Shutdown
+        --
+        [*] --> Charging
+        Charging --> Discharging : Unplug
+        Discharging --> Charging : Plug
+        Discharging --> [*] : Shutdown
+    }
+    Device --> Off
+    Device --> Broken : Drop
+`
+
+func TestStateMachine_ParallelRegions(t *testing.T) {
+	sm, _ := NewBuilder().
+		WithInitialState(&State{Name: "Device"}).
+		AddState(&State{Name: "Disconnected"}).
+		AddState(&State{Name: "Connected"}).
+		AddState(&State{Name: "Charging"}).
+		AddState(&State{Name: "Discharging"}).
+		AddState(&State{Name: "Off"}).
+		AddState(&State{Name: "Broken"}).
+		FromGraph(devicesGraph).
+		Build()
+
+	sm.Start()
+
+	if got := sm.ActiveStates(); !reflect.DeepEqual(got, []string{"Disconnected", "Charging"}) {
+		t.Errorf("Both regions should have been entered, got %v", got)
+	}
+
+	sm.TriggerEvent(event.WithName("Connect"))
+	sm.TriggerEvent(event.WithName("Unplug"))
+
+	if got := sm.ActiveStates(); !reflect.DeepEqual(got, []string{"Connected", "Discharging"}) {
+		t.Errorf("Each region should have its own active state, got %v", got)
+	}
+
+	if !sm.IsInState("Device") || !sm.IsInState("Connected") || !sm.IsInState("Discharging") {
+		t.Error("Device, Connected and Discharging State, should be active")
+	}
+
+	if err := sm.TriggerEvent(event.WithName("Plug")); err != nil {
+		t.Errorf("An event handled by a single region should not fail, got %v", err)
+	}
+
+	if err := sm.TriggerEvent(event.WithName("Unknown")); err == nil {
+		t.Error("An event handled by no region should fail")
+	}
+}
+
+func TestStateMachine_ParallelRegions_Join(t *testing.T) {
+	sm, _ := NewBuilder().
+		WithInitialState(&State{Name: "Device"}).
+		AddState(&State{Name: "Disconnected"}).
+		AddState(&State{Name: "Connected"}).
+		AddState(&State{Name: "Charging"}).
+		AddState(&State{Name: "Discharging"}).
+		AddState(&State{Name: "Off"}).
+		AddState(&State{Name: "Broken"}).
+		FromGraph(devicesGraph).
+		Build()
+
+	sm.Start()
+	sm.TriggerEvent(event.WithName("Connect"))
+
+	if err := sm.TriggerEvent(event.WithName("Shutdown")); err != nil {
+		t.Errorf("Shutdown should have been handled, got %v", err)
+	}
+
+	if sm.CurrentState() != "Device/0/[*]" || !sm.IsInState("Device") {
+		t.Errorf("Device State, should wait for its second region, got %v", sm.ActiveStates())
+	}
+
+	sm.TriggerEvent(event.WithName("Unplug"))
+	sm.TriggerEvent(event.WithName("Shutdown"))
+
+	if sm.CurrentState() != "Off" {
+		t.Errorf("Device State, should have completed once both regions reached their final state, got %v", sm.ActiveStates())
+	}
+}
+
+func TestStateMachine_ParallelRegions_ExitAll(t *testing.T) {
+	var exited []string
+
+	sm, _ := NewStateMachine(&State{Name: "Device"}, context.Background())
+	sm.AddState(&State{Name: "Broken"})
+
+	for _, s := range []struct {
+		name   string
+		region int
+	}{{"Connected", 0}, {"Charging", 1}} {
+		name := s.name
+		sm.AddSubStateInRegion("Device", s.region, &State{Name: name, OnAfter: func(ctx context.Context, trigger Trigger) error {
+			exited = append(exited, name)
+			return nil
+		}})
+	}
+
+	sm.AddTransition("Device", event.WithName("Drop"), "Broken")
+
+	sm.Start()
+
+	if err := sm.TriggerEvent(event.WithName("Drop")); err != nil {
+		t.Fatalf("Drop should have been handled once, got %v", err)
+	}
+
+	if !reflect.DeepEqual(exited, []string{"Connected", "Charging"}) {
+		t.Errorf("All regions should have been exited, got %v", exited)
+	}
+
+	if got := sm.ActiveStates(); !reflect.DeepEqual(got, []string{"Broken"}) {
+		t.Errorf("Only Broken State, should be active, got %v", got)
+	}
+}
+
+func TestStateMachine_ParallelRegions_SnapshotAndExport(t *testing.T) {
+	sm, _ := NewBuilder().
+		WithInitialState(&State{Name: "Device"}).
+		AddState(&State{Name: "Disconnected"}).
+		AddState(&State{Name: "Connected"}).
+		AddState(&State{Name: "Charging"}).
+		AddState(&State{Name: "Discharging"}).
+		AddState(&State{Name: "Off"}).
+		AddState(&State{Name: "Broken"}).
+		FromGraph(devicesGraph).
+		Build()
+
+	sm.Start()
+	sm.TriggerEvent(event.WithName("Connect"))
+
+	restored, _ := NewBuilder().
+		WithInitialState(&State{Name: "Device"}).
+		AddState(&State{Name: "Disconnected"}).
+		AddState(&State{Name: "Connected"}).
+		AddState(&State{Name: "Charging"}).
+		AddState(&State{Name: "Discharging"}).
+		AddState(&State{Name: "Off"}).
+		AddState(&State{Name: "Broken"}).
+		FromGraph(devicesGraph).
+		Build()
+
+	if err := restored.Restore(sm.Snapshot()); err != nil {
+		t.Fatalf("Restore should not have failed: %v", err)
+	}
+
+	if got := restored.ActiveStates(); !reflect.DeepEqual(got, []string{"Connected", "Charging"}) {
+		t.Errorf("All regions should have been restored, got %v", got)
+	}
+
+	graph := sm.ToMermaid()
+
+	if !strings.Contains(graph, "        --\n") {
+		t.Errorf("Regions should have been exported, got %s", graph)
+	}
+
+	roundTrip, err := NewBuilder().
+		WithInitialState(&State{Name: "Device"}).
+		AddState(&State{Name: "Disconnected"}).
+		AddState(&State{Name: "Connected"}).
+		AddState(&State{Name: "Charging"}).
+		AddState(&State{Name: "Discharging"}).
+		AddState(&State{Name: "Off"}).
+		AddState(&State{Name: "Broken"}).
+		FromGraph(graph).
+		Build()
+
+	if err != nil {
+		t.Fatalf("The exported graph should be loadable: %v\n%s", err, graph)
+	}
+
+	if !reflect.DeepEqual(roundTrip.Describe().Transitions, sm.Describe().Transitions) {
+		t.Errorf("The exported graph should describe the same transitions, got %s", graph)
+	}
+}
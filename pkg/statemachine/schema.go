@@ -0,0 +1,433 @@
+package statemachine
+
+import (
+	"context"
+	"fmt"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"gopkg.in/yaml.v3"
+	"io"
+	"time"
+)
+
+// DefinitionError points at the part of a definition loaded by FromDefinition that is invalid, e.g.
+// `states[1].onEnter`.
+type DefinitionError struct {
+	Path string
+	Err  error
+}
+
+func (e *DefinitionError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *DefinitionError) Unwrap() error {
+	return e.Err
+}
+
+// Hook is a hook of a state, see State.
+type Hook func(ctx context.Context, trigger Trigger) error
+
+// schema is the YAML (or JSON) definition of a state machine, see FromDefinition.
+type schema struct {
+	Initial     string             `yaml:"initial"`
+	ErrorState  string             `yaml:"errorState"`
+	Unhandled   string             `yaml:"unhandled"`
+	Version     string             `yaml:"version"`
+	Events      []string           `yaml:"events"`
+	States      []schemaState      `yaml:"states"`
+	Transitions []schemaTransition `yaml:"transitions"`
+}
+
+type schemaState struct {
+	Name           string          `yaml:"name"`
+	OnBefore       string          `yaml:"onBefore"`
+	OnEnter        string          `yaml:"onEnter"`
+	OnAfter        string          `yaml:"onAfter"`
+	Activity       string          `yaml:"activity"`
+	ActivityDone   string          `yaml:"activityDone"`
+	ActivityFailed string          `yaml:"activityFailed"`
+	Unhandled      string          `yaml:"unhandled"`
+	History        string          `yaml:"history"`
+	Initial        string          `yaml:"initial"`
+	States         []schemaState   `yaml:"states"`
+	Regions        [][]schemaState `yaml:"regions"`
+}
+
+type schemaTransition struct {
+	From     string `yaml:"from"`
+	To       string `yaml:"to"`
+	Event    string `yaml:"event"`
+	After    string `yaml:"after"`
+	Guard    string `yaml:"guard"`
+	Action   string `yaml:"action"`
+	Internal bool   `yaml:"internal"`
+}
+
+var unhandledPolicies = map[string]UnhandledPolicy{
+	"error":    UnhandledError,
+	"ignore":   UnhandledIgnore,
+	"fallback": UnhandledFallback,
+	"defer":    UnhandledDefer,
+}
+
+var historyTypes = map[string]HistoryType{
+	"shallow": ShallowHistory,
+	"deep":    DeepHistory,
+}
+
+// FromDefinition loads the states and transitions of the state machine from a YAML (or JSON, being a subset of it)
+// definition:
+//
+//	initial: Idle
+//	errorState: Failed           # optional
+//	unhandled: defer             # optional: error, ignore, fallback or defer
+//	events: [Connect, Connected] # optional, restricts the event names transitions can use
+//	states:
+//	  - name: Idle
+//	  - name: Connecting
+//	    onEnter: dial            # hooks registered with RegisterHook: onBefore, onEnter, onAfter
+//	  - name: Online
+//	    states:                  # sub-states, or `regions` for a list of concurrent regions
+//	      - name: Connected
+//	      - name: Resume
+//	        history: shallow     # a history pseudo-state: shallow or deep
+//	  - name: Failed
+//	transitions:
+//	  - {from: Idle, to: Connecting, event: Connect, guard: isValid, action: notify}
+//	  - {from: Connecting, to: Failed, after: 10s}
+//	  - {from: Connected, to: Connected, event: Ping, internal: true}
+//	  - {from: Failed, to: "[*]"}  # no event nor delay: a completion transition
+//
+// States can also bind an `activity` (a registered action) with the `activityDone` and `activityFailed` events, and
+// have their own `unhandled` policy. A transition to `[*]` from a sub-state reaches the final state of its region.
+// Hooks, guards and actions are resolved by name once building, all the problems are then reported at once by a
+// *ValidationError of *DefinitionError pointing at the offending path.
+func (builder *StateMachineBuilder) FromDefinition(r io.Reader) *StateMachineBuilder {
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+
+	s := &schema{}
+
+	if err := decoder.Decode(s); err != nil {
+		builder.schemaErr = fmt.Errorf("invalid state machine definition: %w", err)
+		return builder
+	}
+
+	builder.schema = s
+
+	return builder
+}
+
+// RegisterHook makes a state hook available to definitions loaded by FromDefinition, where it is referenced by name.
+func (builder *StateMachineBuilder) RegisterHook(name string, hook Hook) *StateMachineBuilder {
+	builder.hooks[name] = hook
+	return builder
+}
+
+// withSchema returns a copy of the builder where the definition was turned into states and transitions.
+func (builder *StateMachineBuilder) withSchema() (*StateMachineBuilder, error) {
+	if builder.schemaErr != nil {
+		return nil, builder.schemaErr
+	}
+
+	b := *builder
+	b.schema = nil
+	b.states = append([]stateBuilder{}, builder.states...)
+	b.transitions = append([]transitionBuilder{}, builder.transitions...)
+	b.timed = append([]timedTransitionBuilder{}, builder.timed...)
+	b.histories = append([]historyBuilder{}, builder.histories...)
+	b.policies = append([]statePolicyBuilder{}, builder.policies...)
+	b.events = append([]eventBuilder{}, builder.events...)
+	b.subFinals = append([]schemaLocation{}, builder.subFinals...)
+	b.initials = map[string]string{}
+
+	for parent, state := range builder.initials {
+		b.initials[parent] = state
+	}
+
+	loader := &schemaLoader{
+		builder: &b,
+		schema:  builder.schema,
+		known:   map[string]schemaLocation{},
+	}
+
+	if err := loader.load(); err != nil {
+		return nil, err
+	}
+
+	return &b, nil
+}
+
+// schemaLocation is where a state of the definition is nested.
+type schemaLocation struct {
+	parent string
+	region int
+}
+
+type schemaLoader struct {
+	builder  *StateMachineBuilder
+	schema   *schema
+	known    map[string]schemaLocation
+	byName   map[string]event.Event
+	declared map[string]bool
+	errs     []error
+}
+
+func (l *schemaLoader) fail(path string, format string, args ...interface{}) {
+	l.errs = append(l.errs, &DefinitionError{Path: path, Err: fmt.Errorf(format, args...)})
+}
+
+func (l *schemaLoader) load() error {
+	s := l.schema
+	b := l.builder
+
+	_, byName, err := b.eventReferenceTable()
+
+	if err != nil {
+		return err
+	}
+
+	l.byName = byName
+	l.declared = map[string]bool{}
+
+	for _, name := range s.Events {
+		l.declared[name] = true
+	}
+
+	b.WithEventNames(s.Events...)
+
+	if b.initialState != nil {
+		l.known[b.initialState.Name] = schemaLocation{}
+
+		if s.Initial != "" && s.Initial != b.initialState.Name {
+			l.fail("initial", "the initial state is already %s", b.initialState.Name)
+		}
+	} else if s.Initial == "" {
+		l.fail("initial", "the initial state is required")
+	}
+
+	for _, st := range b.states {
+		l.known[st.state.Name] = schemaLocation{parent: st.parent, region: st.region}
+	}
+
+	l.addStates("states", s.States, "", 0)
+
+	if b.initialState == nil && s.Initial != "" {
+		l.fail("initial", "%w: %s", ErrUnknownState, s.Initial)
+	}
+
+	if s.ErrorState != "" {
+		if _, ok := l.known[s.ErrorState]; !ok {
+			l.fail("errorState", "%w: %s", ErrUnknownState, s.ErrorState)
+		}
+
+		b.errorState = s.ErrorState
+	}
+
+	if s.Unhandled != "" {
+		b.unhandled = l.policy("unhandled", s.Unhandled)
+	}
+
+	if s.Version != "" {
+		b.version = s.Version
+	}
+
+	for i, t := range s.Transitions {
+		l.addTransition(fmt.Sprintf("transitions[%d]", i), t)
+	}
+
+	if len(l.errs) > 0 {
+		return &ValidationError{Findings: l.errs}
+	}
+
+	return nil
+}
+
+func (l *schemaLoader) addStates(path string, states []schemaState, parent string, region int) {
+	for i, s := range states {
+		l.addState(fmt.Sprintf("%s[%d]", path, i), s, parent, region)
+	}
+}
+
+func (l *schemaLoader) addState(path string, s schemaState, parent string, region int) {
+	b := l.builder
+
+	if s.Name == "" {
+		l.fail(path+".name", "the state name is required")
+		return
+	}
+
+	if _, ok := l.known[s.Name]; ok {
+		l.fail(path+".name", "state already defined %s", s.Name)
+		return
+	}
+
+	l.known[s.Name] = schemaLocation{parent: parent, region: region}
+
+	if s.History != "" {
+		historyType, ok := historyTypes[s.History]
+
+		switch {
+		case !ok:
+			l.fail(path+".history", "unknown history %s, expecting shallow or deep", s.History)
+		case parent == "":
+			l.fail(path+".history", "a history state must be a sub-state")
+		default:
+			b.AddHistoryState(parent, s.Name, historyType)
+		}
+
+		return
+	}
+
+	state := &State{
+		Name:           s.Name,
+		OnBefore:       l.hook(path+".onBefore", s.OnBefore),
+		OnEnter:        l.hook(path+".onEnter", s.OnEnter),
+		OnAfter:        l.hook(path+".onAfter", s.OnAfter),
+		ActivityDone:   l.event(path+".activityDone", s.ActivityDone),
+		ActivityFailed: l.event(path+".activityFailed", s.ActivityFailed),
+	}
+
+	if s.Activity != "" {
+		a, ok := b.actions[s.Activity]
+		if !ok {
+			l.fail(path+".activity", "unknown action: %s", s.Activity)
+		}
+
+		state.Activity = a
+	}
+
+	switch {
+	case parent == "" && s.Name == l.schema.Initial && b.initialState == nil:
+		b.initialState = state
+	case parent == "":
+		b.AddState(state)
+	default:
+		b.AddSubStateInRegion(parent, region, state)
+	}
+
+	if s.Unhandled != "" {
+		b.WithStateUnhandledPolicy(s.Name, l.policy(path+".unhandled", s.Unhandled))
+	}
+
+	if len(s.States) > 0 && len(s.Regions) > 0 {
+		l.fail(path, "a state has either states or regions")
+		return
+	}
+
+	l.addStates(path+".states", s.States, s.Name, 0)
+
+	for r, states := range s.Regions {
+		l.addStates(fmt.Sprintf("%s.regions[%d]", path, r), states, s.Name, r)
+	}
+
+	if s.Initial != "" {
+		if location, ok := l.known[s.Initial]; !ok || location.parent != s.Name {
+			l.fail(path+".initial", "%s is not a sub-state of %s", s.Initial, s.Name)
+		} else {
+			b.WithInitialSubState(s.Name, s.Initial)
+		}
+	}
+}
+
+func (l *schemaLoader) addTransition(path string, t schemaTransition) {
+	b := l.builder
+
+	from, ok := l.known[t.From]
+	if !ok {
+		l.fail(path+".from", "%w: %q", ErrUnknownState, t.From)
+		return
+	}
+
+	to := t.To
+
+	if to == FinalState && from.parent != "" {
+		to = SubFinalStateName(from.parent, from.region)
+		b.subFinals = append(b.subFinals, schemaLocation{parent: from.parent, region: from.region})
+	} else if _, ok := l.known[to]; !ok && to != FinalState {
+		l.fail(path+".to", "%w: %q", ErrUnknownState, t.To)
+		return
+	}
+
+	var opts []TransitionOption
+
+	if t.Guard != "" {
+		guard, ok := b.guards[t.Guard]
+		if !ok {
+			l.fail(path+".guard", "unknown guard: %s", t.Guard)
+		}
+
+		opts = append(opts, WithNamedGuard(t.Guard, guard))
+	}
+
+	if t.Action != "" {
+		a, ok := b.actions[t.Action]
+		if !ok {
+			l.fail(path+".action", "unknown action: %s", t.Action)
+		}
+
+		opts = append(opts, WithNamedAction(t.Action, a))
+	}
+
+	switch {
+	case t.Event != "" && t.After != "":
+		l.fail(path, "a transition has either an event or a delay (after)")
+	case t.Internal && (t.Event == "" || t.To != t.From):
+		l.fail(path+".internal", "an internal transition must be a self-transition on an event")
+	case t.After != "":
+		after, err := time.ParseDuration(t.After)
+
+		if err != nil || after <= 0 {
+			l.fail(path+".after", "invalid delay %s, expecting a positive duration such as 10s", t.After)
+			return
+		}
+
+		b.AddTimedTransition(t.From, after, to, opts...)
+	case t.Event == "":
+		b.AddCompletionTransition(t.From, to, opts...)
+	case t.Internal:
+		b.AddInternalTransition(t.From, l.event(path+".event", t.Event), opts...)
+	default:
+		b.AddTransition(t.From, l.event(path+".event", t.Event), to, opts...)
+	}
+}
+
+func (l *schemaLoader) hook(path string, name string) func(ctx context.Context, trigger Trigger) error {
+	if name == "" {
+		return nil
+	}
+
+	hook, ok := l.builder.hooks[name]
+	if !ok {
+		l.fail(path, "unknown hook: %s", name)
+		return nil
+	}
+
+	return hook
+}
+
+// event returns the event declared on the builder under that name, or a named event.
+func (l *schemaLoader) event(path string, name string) event.Event {
+	if name == "" {
+		return nil
+	}
+
+	if len(l.declared) > 0 && !l.declared[name] {
+		l.fail(path, "event %s is not declared in events", name)
+	}
+
+	if e, ok := l.byName[name]; ok {
+		return e
+	}
+
+	return event.WithName(name)
+}
+
+func (l *schemaLoader) policy(path string, name string) UnhandledPolicy {
+	policy, ok := unhandledPolicies[name]
+	if !ok {
+		l.fail(path, "unknown policy %s, expecting error, ignore, fallback or defer", name)
+	}
+
+	return policy
+}
@@ -0,0 +1,153 @@
+package statemachine
+
+import (
+	"context"
+	"errors"
+	"github.com/a-inacio/edt-go/pkg/action"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const connectionDefinition = `
+initial: Idle
+errorState: Failed
+events: [Connect, Connected, Ping, Drop]
+states:
+  - name: Idle
+  - name: Connecting
+    onEnter: dial
+  - name: Online
+    states:
+      - name: Connected
+      - name: Degraded
+  - name: Failed
+transitions:
+  - {from: Idle, to: Connecting, event: Connect, guard: hasNetwork}
+  - {from: Connecting, to: Online, event: Connected, action: notify}
+  - {from: Connecting, to: Failed, after: 10s}
+  - {from: Connected, to: Connected, event: Ping, internal: true, action: notify}
+  - {from: Connected, to: Degraded, event: Drop}
+  - {from: Degraded, to: "[*]"}
+  - {from: Online, to: "[*]"}
+`
+
+func TestStateMachine_FromDefinition(t *testing.T) {
+	var dialed, notified int
+	clock := &fakeClock{now: time.Unix(0, 0)}
+
+	builder := NewBuilder().
+		RegisterHook("dial", func(ctx context.Context, trigger Trigger) error {
+			dialed++
+			return nil
+		}).
+		RegisterGuard("hasNetwork", func(ctx context.Context, trigger Trigger) bool {
+			return true
+		}).
+		RegisterAction("notify", func(ctx context.Context) (action.Result, error) {
+			notified++
+			return action.Nothing()
+		}).
+		WithClock(clock).
+		FromDefinition(strings.NewReader(connectionDefinition))
+
+	sm, err := builder.Build()
+
+	if err != nil {
+		t.Fatalf("Creating the state machine should not have failed: %v", err)
+	}
+
+	sm.Start()
+	sm.TriggerEvent(event.WithName("Connect"))
+	sm.TriggerEvent(event.WithName("Connected"))
+	sm.TriggerEvent(event.WithName("Ping"))
+
+	if dialed != 1 || notified != 2 || sm.CurrentState() != "Connected" {
+		t.Errorf("Connected State, should be active (dialed %d, notified %d), got %s", dialed, notified, sm.CurrentState())
+	}
+
+	sm.TriggerEvent(event.WithName("Drop"))
+
+	if !sm.IsCompleted() {
+		t.Errorf("The state machine should have completed through the final state of Online, got %v", sm.ActiveStates())
+	}
+
+	// A definition is loaded once, the builder can build as many state machines out of it
+	sm, _ = builder.Build()
+
+	sm.Start()
+	sm.TriggerEvent(event.WithName("Connect"))
+	clock.Advance(10 * time.Second)
+
+	if sm.CurrentState() != "Failed" {
+		t.Errorf("Failed State, should have been entered after 10s, got %s", sm.CurrentState())
+	}
+}
+
+func TestStateMachine_FromDefinition_JSON(t *testing.T) {
+	sm, err := NewBuilder().
+		FromDefinition(strings.NewReader(`{
+			"initial": "A",
+			"states": [{"name": "A"}, {"name": "B"}],
+			"transitions": [{"from": "A", "to": "B", "event": "GoToB"}]
+		}`)).
+		Build()
+
+	if err != nil {
+		t.Fatalf("Creating the state machine should not have failed: %v", err)
+	}
+
+	sm.Start()
+
+	if err = sm.TriggerEvent(event.WithName("GoToB")); err != nil || sm.CurrentState() != "B" {
+		t.Errorf("B State, should be active, got %s (%v)", sm.CurrentState(), err)
+	}
+}
+
+func TestStateMachine_FromDefinition_Errors(t *testing.T) {
+	_, err := NewBuilder().
+		FromDefinition(strings.NewReader(`
+initial: A
+states:
+  - name: A
+    onEnter: missing
+  - name: B
+    unhandled: sometimes
+transitions:
+  - {from: A, to: C, event: Go}
+  - {from: A, to: B, after: soon}
+  - {from: B, to: A, guard: unknown}
+`)).
+		Build()
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Creating the state machine should have failed with a ValidationError, got %v", err)
+	}
+
+	var paths []string
+	for _, f := range validationErr.Findings {
+		var definitionErr *DefinitionError
+		if errors.As(f, &definitionErr) {
+			paths = append(paths, definitionErr.Path)
+		}
+	}
+
+	expected := []string{"states[0].onEnter", "states[1].unhandled", "transitions[0].to", "transitions[1].after", "transitions[2].guard"}
+
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("All problems should have been reported with their path, got %v", paths)
+	}
+
+	if !validationErr.Has(ErrUnknownState) {
+		t.Error("The unknown state should have been reported as ErrUnknownState")
+	}
+
+	_, err = NewBuilder().FromDefinition(strings.NewReader("initial: A\nstate: []\n")).Build()
+
+	if err == nil {
+		t.Error("Unknown fields should have been rejected")
+	}
+}
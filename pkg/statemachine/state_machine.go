@@ -4,14 +4,31 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"github.com/a-inacio/edt-go/pkg/action"
 	"github.com/a-inacio/edt-go/pkg/event"
+	"github.com/a-inacio/edt-go/pkg/eventhub"
+	"github.com/a-inacio/rosetta-logger-go/pkg/logger"
+	"github.com/a-inacio/rosetta-logger-go/pkg/rosetta"
+	"math"
+	"reflect"
+	"sync"
+	"time"
 )
 
+// State is a named state and its hooks, any hook returning an error fails the transition being executed.
 type State struct {
 	Name     string
-	OnBefore func(ctx context.Context, trigger Trigger)
-	OnEnter  func(ctx context.Context, trigger Trigger)
-	OnAfter  func(ctx context.Context, trigger Trigger)
+	OnBefore func(ctx context.Context, trigger Trigger) error
+	OnEnter  func(ctx context.Context, trigger Trigger) error
+	OnAfter  func(ctx context.Context, trigger Trigger) error
+	// Activity is a long-running action (do activity) started, in its own goroutine, once the state is entered. Its
+	// context is cancelled when the state is left.
+	Activity action.Action
+	// ActivityDone is the event triggered, wrapped in an ActivityEvent, once the activity succeeded.
+	ActivityDone event.Event
+	// ActivityFailed is the event triggered, wrapped in an ActivityEvent, once the activity failed, without it the
+	// failure is only logged.
+	ActivityFailed event.Event
 }
 
 type Trigger struct {
@@ -20,10 +37,73 @@ type Trigger struct {
 	ToState   *State
 }
 
+// Guard is a condition evaluated before a transition is taken, the transition is only taken if it returns true.
+type Guard func(ctx context.Context, trigger Trigger) bool
+
 // Transition is a type that represents a transition from one state to another in response to a specific event.
 type Transition struct {
 	EventName string
 	To        *Node
+	Guard     Guard
+	// GuardName is the name the guard was registered with, if any.
+	GuardName string
+	// Action is executed after leaving the source state and before entering the target state.
+	Action action.Action
+	// ActionName is the name the action was registered with, if any.
+	ActionName string
+	// Kind tells if the source state is exited and the target entered, see TransitionKind.
+	Kind TransitionKind
+	// seq is the declaration order of the transition.
+	seq int
+	// effect is an action receiving the trigger, used by the Typed state machine.
+	effect func(ctx context.Context, trigger Trigger) error
+}
+
+// TransitionKind tells how a transition affects its source state.
+type TransitionKind int
+
+const (
+	// ExternalTransition exits the source state and enters the target one, even when they are the same state
+	// (external self-transition), running their hooks and restarting their timers.
+	ExternalTransition TransitionKind = iota
+	// InternalTransition only runs its action, the state it is declared on is neither exited nor entered again.
+	InternalTransition
+)
+
+// TransitionOption customizes a transition when it is added.
+type TransitionOption func(t *Transition)
+
+// WithGuard makes the transition conditional.
+// Several transitions can share the same source state and event, their guards are evaluated in declaration order and
+// the first one passing is taken.
+func WithGuard(guard Guard) TransitionOption {
+	return func(t *Transition) {
+		t.Guard = guard
+	}
+}
+
+// WithNamedGuard is the same as WithGuard, keeping the name for reference.
+func WithNamedGuard(name string, guard Guard) TransitionOption {
+	return func(t *Transition) {
+		t.Guard = guard
+		t.GuardName = name
+	}
+}
+
+// WithAction defines an effect of the transition.
+// The action receives the triggering event in its context, retrievable with event.FromContext.
+func WithAction(a action.Action) TransitionOption {
+	return func(t *Transition) {
+		t.Action = a
+	}
+}
+
+// WithNamedAction is the same as WithAction, keeping the name for reference.
+func WithNamedAction(name string, a action.Action) TransitionOption {
+	return func(t *Transition) {
+		t.Action = a
+		t.ActionName = name
+	}
 }
 
 type NodeType int
@@ -32,20 +112,97 @@ const (
 	InitialNode NodeType = iota
 	TerminalNode
 	ChildNode
+	// HistoryNode is a history pseudo-state, standing for the last active state of its parent composite state.
+	HistoryNode
 )
 
 type Node struct {
 	Type        NodeType
 	State       *State
-	Transitions map[string]Transition
+	Transitions map[string][]Transition
+	// Parent is the composite state enclosing this one, nil for top level states.
+	Parent *Node
+	// Children are the sub-states of a composite state.
+	Children []*Node
+	// Initial is the sub-state entered when a composite state is entered.
+	Initial *Node
+	// Region is the concurrent region of the parent composite state the state belongs to.
+	Region int
+	// RegionInitials are the initial sub-states of each concurrent region, the first one being Initial.
+	RegionInitials []*Node
+	// History is the kind of a history pseudo-state.
+	History HistoryType
+	// Unhandled is the policy for the events not handled while the state is active.
+	Unhandled UnhandledPolicy
+	// index is the declaration order of the state.
+	index int
+}
+
+// IsComposite tells if the node has sub-states.
+func (n *Node) IsComposite() bool {
+	return len(n.Children) > 0
+}
+
+// isDescendantOf tells if the node is nested (at any depth) inside the given ancestor.
+func (n *Node) isDescendantOf(ancestor *Node) bool {
+	for p := n.Parent; p != nil; p = p.Parent {
+		if p == ancestor {
+			return true
+		}
+	}
+
+	return false
 }
 
 // StateMachine is a type that represents a generic state machine.
 type StateMachine struct {
-	nodes   map[string]Node
-	context context.Context
-	current string
-	initial string
+	mu            sync.Mutex
+	cond          *sync.Cond
+	queue         []event.Event
+	queueSize     int
+	queuePolicy   QueuePolicy
+	processing    bool
+	l             logger.Logger
+	nodes         map[string]*Node
+	order         []string
+	transitions   int
+	context       context.Context
+	current       string
+	initial       string
+	errorState    string
+	hub           *eventhub.EventHub
+	hubHandler    *stateMachineHubHandler
+	publishHub    *eventhub.EventHub
+	subscriptions map[string]event.Event
+	onComplete    func(ctx context.Context, trigger Trigger) (action.Result, error)
+	completed     bool
+	done          chan struct{}
+	result        action.Result
+	err           error
+	id            string
+	version       string
+	history       []HistoryEntry
+	historySize   int
+	enteredAt     time.Time
+	lastActive    map[string]string
+	leaves        []*Node
+	entered       []*Node
+	store         Store
+	changed       bool
+	clock         Clock
+	timers        map[timerKey]*armedTimer
+	timerSeq      uint64
+	unhandled     UnhandledPolicy
+	fallback      FallbackHandler
+	deferred      []event.Event
+	activities    map[*Node]*runningActivity
+	activitySeq   uint64
+	tracer        func(step TraceStep)
+	// onDone is called once completed, from the goroutine processing the events, e.g. for a Manager to evict the
+	// instance.
+	onDone func()
+	// shared tells the states and transitions belong to a Definition, they cannot be changed.
+	shared bool
 }
 
 func NewStateMachine(initialState *State, ctx context.Context) (*StateMachine, error) {
@@ -53,24 +210,135 @@ func NewStateMachine(initialState *State, ctx context.Context) (*StateMachine, e
 		return nil, errors.New("state name cannot be empty")
 	}
 
-	return &StateMachine{
-		nodes: map[string]Node{
+	sm := &StateMachine{
+		l: rosetta.NewLogger(logger.NullLoggerType),
+		nodes: map[string]*Node{
 			initialState.Name: {
 				Type:        InitialNode,
 				State:       initialState,
-				Transitions: map[string]Transition{},
+				Transitions: map[string][]Transition{},
+			},
+			FinalState: {
+				Type:        TerminalNode,
+				State:       &State{Name: FinalState},
+				Transitions: map[string][]Transition{},
+				index:       math.MaxInt,
 			},
 		},
-		context: ctx,
-		initial: initialState.Name,
-	}, nil
+		order:       []string{initialState.Name},
+		context:     ctx,
+		initial:     initialState.Name,
+		done:        make(chan struct{}),
+		historySize: DefaultHistorySize,
+		lastActive:  map[string]string{},
+		clock:       SystemClock{},
+		timers:      map[timerKey]*armedTimer{},
+		activities:  map[*Node]*runningActivity{},
+	}
+
+	sm.cond = sync.NewCond(&sm.mu)
+
+	return sm, nil
 }
 
+// IsRunning tells if the state machine was started and did not complete yet.
 func (sm *StateMachine) IsRunning() bool {
-	return sm.current != ""
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	return sm.current != "" && !sm.completed
+}
+
+// CurrentState returns the name of the innermost active state, empty if the state machine is not running.
+// Inside parallel regions, it is the innermost active state of the first region, see ActiveStates.
+func (sm *StateMachine) CurrentState() string {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	return sm.current
+}
+
+// IsInState tells if the given state is active, either being the current state or one of its enclosing composite states.
+func (sm *StateMachine) IsInState(name string) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	for _, leaf := range sm.leaves {
+		for n := leaf; n != nil; n = n.Parent {
+			if n.State.Name == name {
+				return true
+			}
+		}
+	}
+
+	return false
 }
 
 func (sm *StateMachine) AddState(state *State) error {
+	return sm.addNode(state, nil, 0)
+}
+
+// AddSubState adds a state nested inside a composite state.
+// The first sub-state added to a composite state becomes its initial sub-state, unless changed by SetInitialSubState.
+func (sm *StateMachine) AddSubState(parentStateName string, state *State) error {
+	parent, ok := sm.nodes[parentStateName]
+	if !ok {
+		return fmt.Errorf("%w: unknown parent state %s", ErrUnknownState, parentStateName)
+	}
+
+	return sm.addNode(state, parent, 0)
+}
+
+// SetErrorState defines the state entered when a transition fails.
+// Without it, a failing transition is aborted and the state machine stays in the state it was.
+func (sm *StateMachine) SetErrorState(stateName string) error {
+	if sm.shared {
+		return ErrSharedDefinition
+	}
+
+	if _, ok := sm.nodes[stateName]; !ok {
+		return fmt.Errorf("%w: unknown error state %s", ErrUnknownState, stateName)
+	}
+
+	sm.errorState = stateName
+
+	return nil
+}
+
+// SetInitialSubState defines which sub-state is entered when the composite state is entered.
+func (sm *StateMachine) SetInitialSubState(parentStateName string, stateName string) error {
+	if sm.shared {
+		return ErrSharedDefinition
+	}
+
+	parent, ok := sm.nodes[parentStateName]
+	if !ok {
+		return fmt.Errorf("%w: unknown parent state %s", ErrUnknownState, parentStateName)
+	}
+
+	child, ok := sm.nodes[stateName]
+	if !ok {
+		return fmt.Errorf("%w: unknown sub-state %s", ErrUnknownState, stateName)
+	}
+
+	if child.Parent != parent || child.Type != ChildNode {
+		return fmt.Errorf("state %s is not a sub-state of %s", stateName, parentStateName)
+	}
+
+	parent.RegionInitials[child.Region] = child
+
+	if child.Region == 0 {
+		parent.Initial = child
+	}
+
+	return nil
+}
+
+func (sm *StateMachine) addNode(state *State, parent *Node, region int) error {
+	if sm.shared {
+		return ErrSharedDefinition
+	}
+
 	if state.Name == "" {
 		return errors.New("state name cannot be empty")
 	}
@@ -79,101 +347,520 @@ func (sm *StateMachine) AddState(state *State) error {
 		return fmt.Errorf("state already added %s", state.Name)
 	}
 
-	sm.nodes[state.Name] = Node{
+	node := &Node{
 		Type:        ChildNode,
 		State:       state,
-		Transitions: map[string]Transition{},
+		Transitions: map[string][]Transition{},
+		Parent:      parent,
+		Region:      region,
+		index:       len(sm.order),
+	}
+
+	if parent != nil {
+		parent.Children = append(parent.Children, node)
+
+		if region == len(parent.RegionInitials) {
+			parent.RegionInitials = append(parent.RegionInitials, node)
+		}
+
+		if parent.Initial == nil {
+			parent.Initial = node
+		}
 	}
 
+	sm.nodes[state.Name] = node
+	sm.order = append(sm.order, state.Name)
+
 	return nil
 }
 
-func (sm *StateMachine) AddTransition(fromStateName string, e event.Event, toStateName string) error {
-	eventName := event.GetName(e)
+func (sm *StateMachine) AddTransition(fromStateName string, e event.Event, toStateName string, opts ...TransitionOption) error {
+	return sm.addTransition(fromStateName, event.GetName(e), toStateName, opts...)
+}
+
+// AddInternalTransition adds a transition that runs its action without leaving the state, nor any active state nested
+// inside it, so their hooks are not run again and their timers keep running.
+// Use AddTransition with the state as target for an external self-transition.
+func (sm *StateMachine) AddInternalTransition(stateName string, e event.Event, opts ...TransitionOption) error {
+	return sm.addTransition(stateName, event.GetName(e), stateName, append(opts, internal)...)
+}
+
+// internal makes a transition internal, see AddInternalTransition.
+func internal(t *Transition) {
+	t.Kind = InternalTransition
+}
+
+// AddCompletionTransition adds a transition taken as soon as the source state is entered, without waiting for an
+// event, e.g. to complete the state machine with AddCompletionTransition("Done", FinalState).
+func (sm *StateMachine) AddCompletionTransition(fromStateName string, toStateName string, opts ...TransitionOption) error {
+	return sm.addTransition(fromStateName, completionEventName, toStateName, opts...)
+}
+
+func (sm *StateMachine) addTransition(fromStateName string, eventName string, toStateName string, opts ...TransitionOption) error {
+	if sm.shared {
+		return ErrSharedDefinition
+	}
 
 	fromNode, ok := sm.nodes[fromStateName]
 	if !ok {
-		return fmt.Errorf("unknown source state: %s", fromStateName)
+		return fmt.Errorf("%w: unknown source state %s", ErrUnknownState, fromStateName)
 	}
 
-	if _, alreadyAdded := fromNode.Transitions[eventName]; alreadyAdded {
-		return fmt.Errorf("transition already added to %s: %s", fromStateName, eventName)
+	if fromNode.Type == TerminalNode {
+		return fmt.Errorf("the final state cannot have transitions: %s", eventName)
+	}
+
+	if fromNode.Type == HistoryNode {
+		return fmt.Errorf("a history state cannot have transitions: %s", eventName)
+	}
+
+	// An unguarded transition always passes, anything declared after it would never be evaluated
+	for _, t := range fromNode.Transitions[eventName] {
+		if t.Guard == nil {
+			return fmt.Errorf("%w: transition already added to %s: %s", ErrNondeterministicTransition, fromStateName, eventName)
+		}
 	}
 
 	toNode, ok := sm.nodes[toStateName]
 	if !ok {
-		return fmt.Errorf("unknown destination state: %s", toStateName)
+		return fmt.Errorf("%w: unknown destination state %s", ErrUnknownState, toStateName)
 	}
 
-	fromNode.Transitions[eventName] = Transition{
+	sm.transitions++
+
+	transition := Transition{
 		EventName: eventName,
-		To:        &toNode,
+		To:        toNode,
+		seq:       sm.transitions,
+	}
+
+	for _, opt := range opts {
+		opt(&transition)
 	}
 
+	fromNode.Transitions[eventName] = append(fromNode.Transitions[eventName], transition)
+
 	return nil
 }
 
-func (sm *StateMachine) TriggerEvent(e event.Event) error {
-	if !sm.IsRunning() {
+// startEventName is the event name of the transition entering the initial state.
+const startEventName = "__start__"
+
+// processEvent processes an event, then the deferred events the states it entered have a transition for, it must only
+// be called by the goroutine processing the mailbox.
+func (sm *StateMachine) processEvent(e event.Event) error {
+	err := sm.dispatch(e)
+	sm.replayDeferred()
+
+	return err
+}
+
+// dispatch selects and executes the transitions for an event.
+// Inside parallel regions, the event is offered to every region, the first failure is returned.
+func (sm *StateMachine) dispatch(e event.Event) error {
+	if sm.completed {
+		err := fmt.Errorf("%w: %s", ErrCompleted, event.GetName(e))
+		sm.reject(event.GetName(e), err)
+		return err
+	}
+
+	if sm.current == "" {
 		return fmt.Errorf("state machine not started")
 	}
 
-	currentNode := sm.currentNode()
+	if timeout, ok := e.(*Timeout); ok {
+		return sm.processTimeout(timeout)
+	}
+
+	if activity, ok := e.(*ActivityEvent); ok && !sm.isCurrentActivity(activity) {
+		return nil
+	}
 
 	eventName := event.GetName(e)
 
-	transition, exists := currentNode.Transitions[eventName]
-	if !exists {
-		return fmt.Errorf("current state %s has no transition named: %s", sm.current, eventName)
+	selected, err := sm.selectTransitions(eventName, &e)
+
+	if errors.Is(err, ErrUnhandledEvent) {
+		return sm.handleUnhandled(e, err)
 	}
 
-	trigger := Trigger{
-		FromState: currentNode.State,
-		ToState:   transition.To.State,
-		Event:     &e,
+	if err != nil {
+		sm.reject(eventName, err)
+		return err
 	}
 
-	sm.executeTransition(&currentNode, &trigger, &transition)
+	return sm.executeSelected(e, selected)
+}
 
-	return nil
+// executeSelected executes the transitions selected for an event, in each active region.
+func (sm *StateMachine) executeSelected(e event.Event, selected []selectedTransition) error {
+	var failure error
+
+	for _, s := range selected {
+		// A transition taken in another region may have left this one
+		if !sm.isActiveLeaf(s.leaf) {
+			continue
+		}
+
+		trigger := Trigger{
+			FromState: s.leaf.State,
+			ToState:   s.transition.To.State,
+			Event:     &e,
+		}
+
+		if err := sm.executeTransition(s.source, s.leaf, &trigger, s.transition); err != nil && failure == nil {
+			failure = err
+		}
+	}
+
+	return failure
 }
 
-func (sm *StateMachine) Start() error {
-	if sm.IsRunning() {
-		return fmt.Errorf("state machine already runnig at state: %s", sm.current)
+type selectedTransition struct {
+	leaf       *Node
+	source     *Node
+	transition *Transition
+}
+
+// selectTransitions looks for the transition to take in each active region, starting at its innermost active state
+// and going through the enclosing composite states, the innermost state declaring a transition whose guard passes
+// wins. A transition of a state enclosing several regions is only selected once.
+func (sm *StateMachine) selectTransitions(eventName string, e *event.Event) ([]selectedTransition, error) {
+	var selected []selectedTransition
+	seen := map[int]bool{}
+	guarded := false
+
+	for _, leaf := range sm.activeLeaves() {
+		source, transition, rejected := sm.selectTransition(leaf, eventName, e)
+		guarded = guarded || rejected
+
+		if transition == nil || seen[transition.seq] {
+			continue
+		}
+
+		seen[transition.seq] = true
+		selected = append(selected, selectedTransition{leaf: leaf, source: source, transition: transition})
+	}
+
+	if len(selected) > 0 {
+		return selected, nil
+	}
+
+	if guarded {
+		return nil, fmt.Errorf("%w: current state %s, event %s", ErrGuardRejected, sm.activeNames(), eventName)
+	}
+
+	return nil, fmt.Errorf("%w: current state %s has no transition named: %s", ErrUnhandledEvent, sm.activeNames(), eventName)
+}
+
+// selectTransition looks for the transition to take from an innermost active state, rejected tells if transitions
+// were found but their guards did not pass.
+func (sm *StateMachine) selectTransition(leaf *Node, eventName string, e *event.Event) (*Node, *Transition, bool) {
+	rejected := false
+
+	for n := leaf; n != nil; n = n.Parent {
+		for _, t := range n.Transitions[eventName] {
+			if t.Guard == nil {
+				return n, &t, false
+			}
+
+			if t.Guard(sm.context, Trigger{FromState: leaf.State, ToState: t.To.State, Event: e}) {
+				return n, &t, false
+			}
+
+			rejected = true
+		}
 	}
 
+	return nil, nil, rejected
+}
+
+// start enters the initial state, it must only be called by the goroutine processing the mailbox.
+func (sm *StateMachine) start() error {
 	initialNode := sm.nodes[sm.initial]
 	trigger := Trigger{
 		ToState: initialNode.State,
 	}
 
-	sm.executeTransition(nil, &trigger, &Transition{
-		To:        &initialNode,
-		EventName: "__start__",
+	return sm.executeTransition(nil, nil, &trigger, &Transition{
+		To:        initialNode,
+		EventName: startEventName,
 	})
-
-	return nil
 }
 
-func (sm *StateMachine) currentNode() Node {
+// currentNode returns the node of the current state, it must only be called by the goroutine processing the mailbox
+// or while holding the lock.
+func (sm *StateMachine) currentNode() *Node {
 	return sm.nodes[sm.current]
 }
 
-func (sm *StateMachine) executeTransition(currentNode *Node, trigger *Trigger, transition *Transition) {
-	if currentNode != nil {
-		if currentNode.State.OnAfter != nil {
-			currentNode.State.OnAfter(sm.context, *trigger)
+func (sm *StateMachine) setCurrent(name string) {
+	sm.mu.Lock()
+	sm.current = name
+	sm.mu.Unlock()
+}
+
+// executeTransition exits the active states, innermost first, up to the closest state enclosing both source and
+// target, runs the transition action, then enters the target states, outermost first, down to the initial sub-states
+// of the target.
+// The leaf is the innermost active state the transition is taken from, nil when starting.
+// When any of these steps fail, the state machine goes back to the state it was, or enters the error state if one is
+// defined (without running the exit hooks again).
+func (sm *StateMachine) executeTransition(source *Node, leaf *Node, trigger *Trigger, transition *Transition) error {
+	origin := leaf
+	originLeaves := sm.activeLeaves()
+
+	if transition.To.Type == HistoryNode {
+		resolved := *transition
+		resolved.To = sm.resolveHistory(transition.To)
+		transition = &resolved
+		trigger.ToState = resolved.To.State
+	}
+
+	from := ""
+	if origin != nil {
+		from = origin.State.Name
+	}
+
+	eventName := transition.EventName
+	if eventName == startEventName {
+		eventName = ""
+	}
+
+	sm.entered = nil
+
+	err := sm.runTransition(source, trigger, transition)
+
+	if err == nil {
+		sm.settle()
+
+		// The timers and activities of the states left are only cancelled now, a transition rolled back keeps them
+		sm.syncTimers()
+		sm.syncActivities()
+
+		// An internal transition never leaves the state, it is neither a transition of the trace nor of the history
+		if transition.Kind != InternalTransition {
+			sm.trace(TraceStep{Kind: TraceTransition, From: from, To: sm.enteredName(transition.To), Event: eventName})
+			sm.recordHistory(from, sm.enteredName(transition.To), eventName)
+		}
+
+		return sm.afterEntering(trigger)
+	}
+
+	failure := &TransitionError{
+		From:  from,
+		To:    transition.To.State.Name,
+		Event: transition.EventName,
+		Err:   err,
+	}
+
+	sm.trace(TraceStep{Kind: TraceTransition, From: from, To: transition.To.State.Name, Event: eventName, Err: err})
+	sm.setLeaves(originLeaves)
+
+	if sm.errorState != "" && sm.errorState != transition.To.State.Name {
+		errorNode := sm.nodes[sm.errorState]
+		errorTrigger := *trigger
+		errorTrigger.ToState = errorNode.State
+		domain := transitionDomain(origin, errorNode)
+
+		sm.dropLeaves(origin, domain)
+		sm.entered = nil
+
+		failure.ErrorStateErr = sm.enter(domain, errorNode, &errorTrigger)
+		sm.settle()
+
+		if failure.ErrorStateErr == nil {
+			sm.recordHistory(from, sm.enteredName(errorNode), eventName)
+		}
+	}
+
+	sm.syncTimers()
+	sm.syncActivities()
+
+	sm.publish(TransitionFailed{
+		Machine: sm.id,
+		From:    failure.From,
+		To:      failure.To,
+		Event:   failure.Event,
+		Err:     failure,
+	})
+
+	return failure
+}
+
+// enteredName returns the first innermost state entered by the transition, or its target.
+func (sm *StateMachine) enteredName(target *Node) string {
+	if len(sm.entered) > 0 {
+		return sm.entered[0].State.Name
+	}
+
+	return target.State.Name
+}
+
+func (sm *StateMachine) runTransition(source *Node, trigger *Trigger, transition *Transition) error {
+	target := transition.To
+	domain := transitionDomain(source, target)
+
+	isInternal := transition.Kind == InternalTransition
+
+	if source != nil && !isInternal {
+		for _, step := range sm.exitSet(source, domain) {
+			n := step.node
+
+			if n.Parent != nil {
+				sm.rememberActive(n.Parent, step.leaf)
+			}
+
+			sm.removeLeaf(n)
+			sm.trace(TraceStep{Kind: TraceExit, State: n.State.Name})
+
+			if err := sm.runHook(n, "OnAfter", n.State.OnAfter, trigger); err != nil {
+				return err
+			}
+
+			sm.publish(StateExited{
+				Machine: sm.id,
+				State:   n.State.Name,
+				To:      trigger.ToState.Name,
+				Event:   triggerEventName(trigger),
+			})
+		}
+	}
+
+	if transition.Action != nil {
+		ctx := sm.context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		if trigger.Event != nil {
+			ctx = context.WithValue(ctx, reflect.TypeOf(*trigger.Event).PkgPath(), *trigger.Event)
+		}
+
+		_, err := transition.Action(ctx)
+		sm.traceAction(trigger, transition, err)
+
+		if err != nil {
+			return fmt.Errorf("transition action: %w", err)
+		}
+	}
+
+	if transition.effect != nil {
+		err := transition.effect(sm.context, *trigger)
+		sm.traceAction(trigger, transition, err)
+
+		if err != nil {
+			return fmt.Errorf("transition action: %w", err)
+		}
+	}
+
+	if isInternal {
+		return nil
+	}
+
+	return sm.enter(domain, target, trigger)
+}
+
+// enter enters the states from right below the domain down to the target, then its initial sub-states.
+// Entering a parallel state enters all its regions: the other regions than the one leading to the target are entered
+// through their initial sub-states.
+func (sm *StateMachine) enter(domain *Node, target *Node, trigger *Trigger) error {
+	var path []*Node
+	for n := target; n != domain; n = n.Parent {
+		path = append([]*Node{n}, path...)
+	}
+
+	for i, n := range path {
+		if err := sm.enterNode(n, trigger); err != nil {
+			return err
+		}
+
+		if !n.IsParallel() || i+1 == len(path) {
+			continue
+		}
+
+		for region, initial := range n.RegionInitials {
+			if region == path[i+1].Region {
+				continue
+			}
+
+			if err := sm.enterDefault(initial, trigger); err != nil {
+				return err
+			}
 		}
 	}
 
-	sm.current = transition.To.State.Name
+	return sm.enterSubStates(target, trigger)
+}
 
-	if transition.To.State.OnBefore != nil {
-		transition.To.State.OnBefore(sm.context, *trigger)
+// enterDefault enters a state and its initial sub-states.
+func (sm *StateMachine) enterDefault(node *Node, trigger *Trigger) error {
+	if err := sm.enterNode(node, trigger); err != nil {
+		return err
 	}
 
-	if transition.To.State.OnEnter != nil {
-		transition.To.State.OnEnter(sm.context, *trigger)
+	return sm.enterSubStates(node, trigger)
+}
+
+// enterSubStates enters the initial sub-states of a composite state, one per region.
+func (sm *StateMachine) enterSubStates(node *Node, trigger *Trigger) error {
+	for _, initial := range node.RegionInitials {
+		if err := sm.enterDefault(initial, trigger); err != nil {
+			return err
+		}
 	}
+
+	return nil
+}
+
+func (sm *StateMachine) enterNode(n *Node, trigger *Trigger) error {
+	sm.setCurrent(n.State.Name)
+
+	if !n.IsComposite() {
+		sm.addLeaf(n)
+		sm.entered = append(sm.entered, n)
+	}
+
+	sm.trace(TraceStep{Kind: TraceEnter, State: n.State.Name})
+
+	if err := sm.runHook(n, "OnBefore", n.State.OnBefore, trigger); err != nil {
+		return err
+	}
+
+	if err := sm.runHook(n, "OnEnter", n.State.OnEnter, trigger); err != nil {
+		return err
+	}
+
+	sm.armTimers(n)
+	sm.startActivity(n)
+
+	from := ""
+	if trigger.FromState != nil {
+		from = trigger.FromState.Name
+	}
+
+	sm.publish(StateEntered{
+		Machine: sm.id,
+		State:   n.State.Name,
+		From:    from,
+		Event:   triggerEventName(trigger),
+	})
+
+	return nil
+}
+
+// transitionDomain returns the innermost composite state that properly encloses both source and target, nil when
+// that is the state machine itself.
+func transitionDomain(source *Node, target *Node) *Node {
+	if source == nil {
+		return nil
+	}
+
+	for n := source.Parent; n != nil; n = n.Parent {
+		if target.isDescendantOf(n) {
+			return n
+		}
+	}
+
+	return nil
 }
@@ -2,8 +2,11 @@ package statemachine
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/a-inacio/edt-go/pkg/action"
 	"github.com/a-inacio/edt-go/pkg/delayable"
+	"github.com/a-inacio/edt-go/pkg/event"
 	"testing"
 	"time"
 )
@@ -14,11 +17,13 @@ func TestNewStateMachine(t *testing.T) {
 
 	initialState := &State{
 		Name: "Initial",
-		OnBefore: func(ctx context.Context, trigger Trigger) {
+		OnBefore: func(ctx context.Context, trigger Trigger) error {
 			onBeforeCalled = true
+			return nil
 		},
-		OnEnter: func(ctx context.Context, trigger Trigger) {
+		OnEnter: func(ctx context.Context, trigger Trigger) error {
 			onEnterCalled = true
+			return nil
 		},
 	}
 	sm, err := NewStateMachine(initialState, context.Background())
@@ -45,19 +50,22 @@ func TestStateMachine_TriggerEvent(t *testing.T) {
 
 	initialState := &State{
 		Name: "A",
-		OnAfter: func(ctx context.Context, trigger Trigger) {
+		OnAfter: func(ctx context.Context, trigger Trigger) error {
 			onAfterCalledA = true
+			return nil
 		},
 	}
 	sm, _ := NewStateMachine(initialState, context.Background())
 
 	sm.AddState(&State{
 		Name: "B",
-		OnBefore: func(ctx context.Context, trigger Trigger) {
+		OnBefore: func(ctx context.Context, trigger Trigger) error {
 			onBeforeCalledB = true
+			return nil
 		},
-		OnEnter: func(ctx context.Context, trigger Trigger) {
+		OnEnter: func(ctx context.Context, trigger Trigger) error {
 			onEnterCalledB = true
+			return nil
 		},
 	})
 
@@ -122,14 +130,16 @@ func TestNewStateMachine_Cancellation(t *testing.T) {
 
 	initialState := &State{
 		Name: "Initial",
-		OnBefore: func(ctx context.Context, trigger Trigger) {
+		OnBefore: func(ctx context.Context, trigger Trigger) error {
 			onBeforeCalled = true
+			return nil
 		},
-		OnEnter: func(ctx context.Context, trigger Trigger) {
+		OnEnter: func(ctx context.Context, trigger Trigger) error {
 			delayable.RunAfter(ctx, 5*time.Second, func(ctx context.Context) (action.Result, error) {
 				onEnterCalled = true
 				return action.Nothing()
 			})
+			return nil
 		},
 	}
 	sm, err := NewStateMachine(initialState, ctx)
@@ -148,3 +158,439 @@ func TestNewStateMachine_Cancellation(t *testing.T) {
 		t.Error("Initial State, OnEnter should have not been called")
 	}
 }
+
+func TestStateMachine_TriggerEvent_Hierarchical(t *testing.T) {
+	var calls []string
+
+	record := func(name string) *State {
+		return &State{
+			Name: name,
+			OnBefore: func(ctx context.Context, trigger Trigger) error {
+				calls = append(calls, "before "+name)
+				return nil
+			},
+			OnEnter: func(ctx context.Context, trigger Trigger) error {
+				calls = append(calls, "enter "+name)
+				return nil
+			},
+			OnAfter: func(ctx context.Context, trigger Trigger) error {
+				calls = append(calls, "after "+name)
+				return nil
+			},
+		}
+	}
+
+	sm, _ := NewStateMachine(record("Disconnected"), context.Background())
+
+	sm.AddState(record("Connected"))
+	sm.AddSubState("Connected", record("Idle"))
+	sm.AddSubState("Connected", record("Syncing"))
+	sm.AddSubState("Syncing", record("Uploading"))
+
+	type Connect struct {
+	}
+
+	type Sync struct {
+	}
+
+	type Disconnect struct {
+	}
+
+	sm.AddTransition("Disconnected", Connect{}, "Connected")
+	sm.AddTransition("Idle", Sync{}, "Syncing")
+	sm.AddTransition("Connected", Disconnect{}, "Disconnected")
+
+	sm.Start()
+	calls = nil
+
+	if err := sm.TriggerEvent(Connect{}); err != nil {
+		t.Errorf("Disconnected State, should be possible to connect: %v", err)
+	}
+
+	if sm.CurrentState() != "Idle" {
+		t.Errorf("Connected State, should have entered the initial sub-state Idle, got %s", sm.CurrentState())
+	}
+
+	if err := sm.TriggerEvent(Sync{}); err != nil {
+		t.Errorf("Idle State, should be possible to sync: %v", err)
+	}
+
+	if sm.CurrentState() != "Uploading" || !sm.IsInState("Syncing") || !sm.IsInState("Connected") {
+		t.Errorf("Syncing State, should be in Connected > Syncing > Uploading, got %s", sm.CurrentState())
+	}
+
+	calls = nil
+
+	if err := sm.TriggerEvent(Disconnect{}); err != nil {
+		t.Errorf("Uploading State, should inherit the Disconnect transition from Connected: %v", err)
+	}
+
+	expected := []string{
+		"after Uploading",
+		"after Syncing",
+		"after Connected",
+		"before Disconnected",
+		"enter Disconnected",
+	}
+
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Errorf("Hooks should have been called innermost first, expected %v, got %v", expected, calls)
+	}
+
+	if sm.IsInState("Connected") {
+		t.Error("Disconnected State, should have left Connected")
+	}
+}
+
+func TestStateMachine_TriggerEvent_Hierarchical_EnterOutermostFirst(t *testing.T) {
+	var calls []string
+
+	record := func(name string) *State {
+		return &State{
+			Name: name,
+			OnEnter: func(ctx context.Context, trigger Trigger) error {
+				calls = append(calls, "enter "+name)
+				return nil
+			},
+			OnAfter: func(ctx context.Context, trigger Trigger) error {
+				calls = append(calls, "after "+name)
+				return nil
+			},
+		}
+	}
+
+	sm, _ := NewStateMachine(record("A"), context.Background())
+
+	sm.AddState(record("B"))
+	sm.AddSubState("B", record("B1"))
+	sm.AddSubState("B", record("B2"))
+	sm.AddSubState("B2", record("B21"))
+	sm.AddSubState("B2", record("B22"))
+	sm.SetInitialSubState("B2", "B22")
+
+	type GoToB2 struct {
+	}
+
+	type GoToB1 struct {
+	}
+
+	sm.AddTransition("A", GoToB2{}, "B2")
+	sm.AddTransition("B2", GoToB1{}, "B1")
+
+	sm.Start()
+	calls = nil
+
+	sm.TriggerEvent(GoToB2{})
+
+	expected := []string{"after A", "enter B", "enter B2", "enter B22"}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Errorf("Hooks should have been called outermost first, expected %v, got %v", expected, calls)
+	}
+
+	calls = nil
+
+	sm.TriggerEvent(GoToB1{})
+
+	expected = []string{"after B22", "after B2", "enter B1"}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Errorf("B should have not been left, expected %v, got %v", expected, calls)
+	}
+}
+
+func TestStateMachine_TriggerEvent_Guarded(t *testing.T) {
+	type Order struct {
+		Amount int
+	}
+
+	isBig := func(ctx context.Context, trigger Trigger) bool {
+		order, _ := event.ValueOf[Order](*trigger.Event)
+		return order != nil && order.Amount > 100
+	}
+
+	isSmall := func(ctx context.Context, trigger Trigger) bool {
+		order, _ := event.ValueOf[Order](*trigger.Event)
+		return order != nil && order.Amount <= 10
+	}
+
+	newStateMachine := func() *StateMachine {
+		sm, _ := NewStateMachine(&State{Name: "Received"}, context.Background())
+
+		sm.AddState(&State{Name: "Review"})
+		sm.AddState(&State{Name: "Approved"})
+
+		sm.AddTransition("Received", Order{}, "Review", WithGuard(isBig))
+		sm.AddTransition("Received", Order{}, "Approved", WithGuard(isSmall))
+
+		sm.Start()
+
+		return sm
+	}
+
+	sm := newStateMachine()
+	if err := sm.TriggerEvent(Order{Amount: 1000}); err != nil || sm.CurrentState() != "Review" {
+		t.Errorf("Received State, a big order should go to Review, got %s (%v)", sm.CurrentState(), err)
+	}
+
+	sm = newStateMachine()
+	if err := sm.TriggerEvent(Order{Amount: 5}); err != nil || sm.CurrentState() != "Approved" {
+		t.Errorf("Received State, a small order should go to Approved, got %s (%v)", sm.CurrentState(), err)
+	}
+
+	sm = newStateMachine()
+	err := sm.TriggerEvent(Order{Amount: 50})
+	if !errors.Is(err, ErrGuardRejected) {
+		t.Errorf("Received State, no guard should have passed, got %v", err)
+	}
+
+	if sm.CurrentState() != "Received" {
+		t.Errorf("Received State, should have not moved, got %s", sm.CurrentState())
+	}
+
+	if err := sm.AddTransition("Received", Order{}, "Review"); err != nil {
+		t.Error("an unguarded fallback transition should be accepted after guarded ones")
+	}
+
+	if err := sm.AddTransition("Received", Order{}, "Approved"); err == nil {
+		t.Error("a transition after an unguarded one should be rejected")
+	}
+
+	if err := sm.TriggerEvent(Order{Amount: 50}); err != nil || sm.CurrentState() != "Review" {
+		t.Errorf("Received State, the fallback should have been taken, got %s (%v)", sm.CurrentState(), err)
+	}
+}
+
+func TestStateMachine_TriggerEvent_WithAction(t *testing.T) {
+	type Pay struct {
+		Amount int
+	}
+
+	var calls []string
+	paid := 0
+
+	sm, _ := NewStateMachine(&State{
+		Name: "Pending",
+		OnAfter: func(ctx context.Context, trigger Trigger) error {
+			calls = append(calls, "after Pending")
+			return nil
+		},
+	}, context.Background())
+
+	sm.AddState(&State{
+		Name: "Paid",
+		OnEnter: func(ctx context.Context, trigger Trigger) error {
+			calls = append(calls, "enter Paid")
+			return nil
+		},
+	})
+
+	sm.AddTransition("Pending", Pay{}, "Paid", WithAction(func(ctx context.Context) (action.Result, error) {
+		calls = append(calls, "action")
+		ev, _ := event.FromContext[Pay](ctx)
+		paid = ev.Amount
+		return action.Nothing()
+	}))
+
+	sm.Start()
+
+	if err := sm.TriggerEvent(Pay{Amount: 42}); err != nil {
+		t.Errorf("Pending State, should be possible to pay: %v", err)
+	}
+
+	expected := []string{"after Pending", "action", "enter Paid"}
+	if fmt.Sprint(calls) != fmt.Sprint(expected) {
+		t.Errorf("The action should run between exit and entry, expected %v, got %v", expected, calls)
+	}
+
+	if paid != 42 {
+		t.Errorf("The action should have received the event, got %d", paid)
+	}
+}
+
+func TestStateMachine_TriggerEvent_Failing(t *testing.T) {
+	type GoToB struct {
+	}
+
+	type GoToC struct {
+	}
+
+	failure := errors.New("I was asked to fail")
+
+	newStateMachine := func() *StateMachine {
+		sm, _ := NewStateMachine(&State{Name: "A"}, context.Background())
+
+		sm.AddState(&State{
+			Name: "B",
+			OnEnter: func(ctx context.Context, trigger Trigger) error {
+				return failure
+			},
+		})
+
+		sm.AddState(&State{Name: "C"})
+		sm.AddState(&State{Name: "Failed"})
+
+		sm.AddTransition("A", GoToB{}, "B")
+		sm.AddTransition("A", GoToC{}, "C", WithAction(func(ctx context.Context) (action.Result, error) {
+			return action.FromError(failure)
+		}))
+
+		sm.Start()
+
+		return sm
+	}
+
+	sm := newStateMachine()
+
+	err := sm.TriggerEvent(GoToB{})
+
+	var transitionErr *TransitionError
+	if !errors.As(err, &transitionErr) || !errors.Is(err, failure) {
+		t.Fatalf("A State, the transition to B should have failed with a TransitionError, got %v", err)
+	}
+
+	if transitionErr.From != "A" || transitionErr.To != "B" {
+		t.Errorf("The error should describe the transition, got %v", transitionErr)
+	}
+
+	if sm.CurrentState() != "A" {
+		t.Errorf("A State, should have stayed in A, got %s", sm.CurrentState())
+	}
+
+	if err := sm.TriggerEvent(GoToC{}); !errors.Is(err, failure) {
+		t.Errorf("A State, the failing action should have aborted the transition, got %v", err)
+	}
+
+	if sm.CurrentState() != "A" {
+		t.Errorf("A State, should have stayed in A, got %s", sm.CurrentState())
+	}
+
+	sm = newStateMachine()
+	sm.SetErrorState("Failed")
+
+	if err := sm.TriggerEvent(GoToB{}); !errors.Is(err, failure) {
+		t.Errorf("A State, the transition to B should have failed, got %v", err)
+	}
+
+	if sm.CurrentState() != "Failed" {
+		t.Errorf("A State, should have entered the error state, got %s", sm.CurrentState())
+	}
+}
+
+func TestStateMachine_TriggerEvent_Completion(t *testing.T) {
+	type Finish struct {
+		Total int
+	}
+
+	sm, _ := NewStateMachine(&State{Name: "Working"}, context.Background())
+
+	sm.AddTransition("Working", Finish{}, FinalState)
+	sm.SetOnComplete(func(ctx context.Context, trigger Trigger) (action.Result, error) {
+		finish, _ := event.ValueOf[Finish](*trigger.Event)
+		return finish.Total, nil
+	})
+
+	sm.Start()
+
+	select {
+	case <-sm.Done():
+		t.Fatal("Working State, should not have completed yet")
+	default:
+	}
+
+	if err := sm.TriggerEvent(Finish{Total: 42}); err != nil {
+		t.Errorf("Working State, should be possible to finish: %v", err)
+	}
+
+	select {
+	case <-sm.Done():
+	case <-time.After(time.Second):
+		t.Fatal("The state machine should have completed")
+	}
+
+	if sm.IsRunning() || !sm.IsCompleted() {
+		t.Error("The state machine should no longer be running")
+	}
+
+	res, err := sm.Result()
+	if res != 42 || err != nil {
+		t.Errorf("The result should come from the on complete hook, got %v (%v)", res, err)
+	}
+
+	if err := sm.TriggerEvent(Finish{}); !errors.Is(err, ErrCompleted) {
+		t.Errorf("Events should be rejected once completed, got %v", err)
+	}
+
+	if err := sm.Start(); !errors.Is(err, ErrCompleted) {
+		t.Errorf("A completed state machine should not start again, got %v", err)
+	}
+
+	if err := sm.AddTransition(FinalState, Finish{}, "Working"); err == nil {
+		t.Error("The final state should not have transitions")
+	}
+}
+
+func TestStateMachine_InternalAndSelfTransitions(t *testing.T) {
+	entered := 0
+	exited := 0
+	retries := 0
+
+	sm, _ := NewStateMachine(&State{
+		Name: "Retrying",
+		OnEnter: func(ctx context.Context, trigger Trigger) error {
+			entered++
+			return nil
+		},
+		OnAfter: func(ctx context.Context, trigger Trigger) error {
+			exited++
+			return nil
+		},
+	}, context.Background())
+
+	sm.AddInternalTransition("Retrying", event.WithName("Retry"), WithAction(func(ctx context.Context) (action.Result, error) {
+		retries++
+		return action.Nothing()
+	}))
+	sm.AddTransition("Retrying", event.WithName("Reset"), "Retrying")
+
+	sm.Start()
+
+	if err := sm.TriggerEvent(event.WithName("Retry")); err != nil {
+		t.Fatalf("Retry should have been handled, got %v", err)
+	}
+
+	if retries != 1 || entered != 1 || exited != 0 {
+		t.Errorf("Retrying State, should not have been left by an internal transition (entered %d, exited %d)", entered, exited)
+	}
+
+	sm.TriggerEvent(event.WithName("Reset"))
+
+	if entered != 2 || exited != 1 {
+		t.Errorf("Retrying State, should have been re-entered by an external self-transition (entered %d, exited %d)", entered, exited)
+	}
+}
+
+func TestStateMachine_InternalTransition_History(t *testing.T) {
+	clock := &fakeClock{}
+
+	sm, _ := NewStateMachine(&State{Name: "Retrying"}, context.Background())
+	sm.SetClock(clock)
+	sm.AddState(&State{Name: "Done"})
+	sm.AddInternalTransition("Retrying", event.WithName("Retry"))
+	sm.AddTransition("Retrying", event.WithName("Finish"), "Done")
+
+	sm.Start()
+
+	clock.Advance(3 * time.Second)
+	sm.TriggerEvent(event.WithName("Retry"))
+	clock.Advance(2 * time.Second)
+	sm.TriggerEvent(event.WithName("Finish"))
+
+	history := sm.History()
+
+	if len(history) != 2 || history[1].From != "Retrying" || history[1].To != "Done" {
+		t.Fatalf("Retrying State, the internal transition should not be in the history, got %+v", history)
+	}
+
+	if history[1].Duration != 5*time.Second {
+		t.Errorf("Retrying State, should have been active for 5s, got %v", history[1].Duration)
+	}
+}
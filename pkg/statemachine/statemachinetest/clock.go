@@ -0,0 +1,98 @@
+package statemachinetest
+
+import (
+	"github.com/a-inacio/edt-go/pkg/statemachine"
+	"sync"
+	"time"
+)
+
+// Clock is a fake statemachine.Clock that only moves forward when advanced, due timers are fired by Advance, in
+// order and in the calling goroutine, so timed transitions are taken before it returns.
+type Clock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*timer
+}
+
+type timer struct {
+	clock   *Clock
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func (t *timer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	wasPending := !t.stopped
+	t.stopped = true
+
+	return wasPending
+}
+
+// NewClock creates a fake clock set at the given time.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *Clock) AfterFunc(d time.Duration, f func()) statemachine.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &timer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+
+	return t
+}
+
+// Advance moves the clock forward, firing the timers that are due, earliest first. Timers armed by the fired ones are
+// fired as well when they are due.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	end := c.now.Add(d)
+	c.mu.Unlock()
+
+	for {
+		c.mu.Lock()
+
+		var next *timer
+		for _, t := range c.timers {
+			if !t.stopped && !t.at.After(end) && (next == nil || t.at.Before(next.at)) {
+				next = t
+			}
+		}
+
+		if next == nil {
+			c.now = end
+			c.prune()
+			c.mu.Unlock()
+			return
+		}
+
+		next.stopped = true
+		c.now = next.at
+		c.mu.Unlock()
+
+		next.f()
+	}
+}
+
+// prune forgets the timers that were fired or stopped, it must be called while holding the lock.
+func (c *Clock) prune() {
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if !t.stopped {
+			pending = append(pending, t)
+		}
+	}
+
+	c.timers = pending
+}
@@ -0,0 +1,243 @@
+// Package statemachinetest provides utilities for testing state machines: a harness driving a state machine with a
+// scripted sequence of events, recording every step of its execution and asserting on it, a fake clock for timed
+// behaviour and golden files for the recorded trace.
+package statemachinetest
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"github.com/a-inacio/edt-go/pkg/statemachine"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("statemachinetest.update", false, "update the golden files of the state machine traces")
+
+// Harness drives a state machine in a test and records its execution.
+type Harness struct {
+	t     testing.TB
+	sm    *statemachine.StateMachine
+	mu    sync.Mutex
+	steps []statemachine.TraceStep
+	// Clock is the fake clock of the state machine, timed transitions are taken when it is advanced.
+	Clock *Clock
+}
+
+// Step is a step of a script, see Run.
+type Step func(h *Harness)
+
+// New wires the harness to a state machine that was not started yet: it records its execution and replaces its clock
+// by a fake one, set at the Unix epoch.
+func New(t testing.TB, sm *statemachine.StateMachine) *Harness {
+	h := &Harness{
+		t:     t,
+		sm:    sm,
+		Clock: NewClock(time.Unix(0, 0).UTC()),
+	}
+
+	sm.SetClock(h.Clock)
+	sm.SetTracer(h.record)
+
+	return h
+}
+
+func (h *Harness) record(step statemachine.TraceStep) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.steps = append(h.steps, step)
+}
+
+// Machine returns the state machine under test.
+func (h *Harness) Machine() *statemachine.StateMachine {
+	return h.sm
+}
+
+// Start starts the state machine, failing the test if it cannot be.
+func (h *Harness) Start() *Harness {
+	h.t.Helper()
+
+	if err := h.sm.Start(); err != nil {
+		h.t.Fatalf("Starting the state machine should not have failed: %v", err)
+	}
+
+	return h
+}
+
+// Run plays a script, step by step.
+func (h *Harness) Run(script ...Step) *Harness {
+	h.t.Helper()
+
+	for _, step := range script {
+		step(h)
+	}
+
+	return h
+}
+
+// Send triggers an event, failing the test if it is not processed.
+func Send(e event.Event) Step {
+	return func(h *Harness) {
+		h.t.Helper()
+
+		if err := h.sm.TriggerEvent(e); err != nil {
+			h.t.Fatalf("%s should have been processed in %s: %v", event.GetName(e), h.sm.CurrentState(), err)
+		}
+	}
+}
+
+// Reject triggers an event that must fail, with an error matching target (see errors.Is), any error if target is nil.
+func Reject(e event.Event, target error) Step {
+	return func(h *Harness) {
+		h.t.Helper()
+
+		err := h.sm.TriggerEvent(e)
+
+		if err == nil || (target != nil && !errors.Is(err, target)) {
+			h.t.Fatalf("%s should have been rejected in %s with %v, got %v", event.GetName(e), h.sm.CurrentState(), target, err)
+		}
+	}
+}
+
+// Advance moves the fake clock forward, taking the timed transitions that are due.
+func Advance(d time.Duration) Step {
+	return func(h *Harness) {
+		h.Clock.Advance(d)
+	}
+}
+
+// Steps returns the recorded steps, see statemachine.TraceStep.
+func (h *Harness) Steps() []statemachine.TraceStep {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]statemachine.TraceStep{}, h.steps...)
+}
+
+// Trace returns the recorded steps, one per line.
+func (h *Harness) Trace() string {
+	var b strings.Builder
+
+	for _, step := range h.Steps() {
+		b.WriteString(step.String())
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// Path returns the states entered, in order, composite states included.
+func (h *Harness) Path() []string {
+	var path []string
+
+	for _, step := range h.Steps() {
+		if step.Kind == statemachine.TraceEnter {
+			path = append(path, step.State)
+		}
+	}
+
+	return path
+}
+
+// AssertPath checks the states entered, in order, see Path.
+func (h *Harness) AssertPath(states ...string) {
+	h.t.Helper()
+
+	if path := h.Path(); !reflect.DeepEqual(path, states) {
+		h.t.Errorf("The state machine should have gone through %v, got %v", states, path)
+	}
+}
+
+// AssertNever checks no transition was taken from one innermost state to another.
+func (h *Harness) AssertNever(from string, to string) {
+	h.t.Helper()
+
+	for _, step := range h.Steps() {
+		if step.Kind == statemachine.TraceTransition && step.Err == nil && step.From == from && step.To == to {
+			h.t.Errorf("The state machine should never have gone from %s to %s (on %s)", from, to, step.Event)
+			return
+		}
+	}
+}
+
+// AssertState checks the state is active.
+func (h *Harness) AssertState(state string) {
+	h.t.Helper()
+
+	if !h.sm.IsInState(state) {
+		h.t.Errorf("%s State, should be active, got %v", state, h.sm.ActiveStates())
+	}
+}
+
+// AssertCompleted checks the state machine reached its final state.
+func (h *Harness) AssertCompleted() {
+	h.t.Helper()
+
+	if !h.sm.IsCompleted() {
+		h.t.Errorf("The state machine should have completed, got %v", h.sm.ActiveStates())
+	}
+}
+
+// AssertGolden compares the trace with the content of a golden file, usually under testdata. Running the tests with
+// -statemachinetest.update writes the trace to the file instead.
+func (h *Harness) AssertGolden(path string) {
+	h.t.Helper()
+
+	trace := h.Trace()
+
+	if *update {
+		if err := writeGolden(path, trace); err != nil {
+			h.t.Fatalf("Updating %s should not have failed: %v", path, err)
+		}
+		return
+	}
+
+	expected, err := os.ReadFile(path)
+
+	if err != nil {
+		h.t.Fatalf("Reading %s should not have failed (run with -statemachinetest.update to create it): %v", path, err)
+	}
+
+	if string(expected) != trace {
+		h.t.Errorf("The trace should match %s\n%s", path, diff(string(expected), trace))
+	}
+}
+
+func writeGolden(path string, trace string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, []byte(trace), 0o644)
+}
+
+// diff describes the first difference between the expected and the actual trace.
+func diff(expected string, actual string) string {
+	e := strings.Split(expected, "\n")
+	a := strings.Split(actual, "\n")
+
+	for i := 0; i < len(e) || i < len(a); i++ {
+		var el, al string
+
+		if i < len(e) {
+			el = e[i]
+		}
+
+		if i < len(a) {
+			al = a[i]
+		}
+
+		if el != al {
+			return fmt.Sprintf("line %d:\n  expected: %q\n  actual:   %q", i+1, el, al)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,87 @@
+package statemachinetest
+
+import (
+	"context"
+	"github.com/a-inacio/edt-go/pkg/action"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"github.com/a-inacio/edt-go/pkg/statemachine"
+	"testing"
+	"time"
+)
+
+func TestHarness_Run(t *testing.T) {
+	sm, _ := statemachine.NewBuilder().
+		WithInitialState(&statemachine.State{Name: "Idle"}).
+		AddState(&statemachine.State{
+			Name: "Connecting",
+			OnEnter: func(ctx context.Context, trigger statemachine.Trigger) error {
+				return nil
+			},
+		}).
+		AddState(&statemachine.State{Name: "Connected"}).
+		AddState(&statemachine.State{Name: "Failed"}).
+		RegisterAction("dial", func(ctx context.Context) (action.Result, error) {
+			return action.Nothing()
+		}).
+		FromGraph(`
+			[*] --> Idle
+			Idle --> Connecting: Connect / dial
+			Connecting --> Connected: Connected
+			Connecting --> Failed: after 10s
+			Connected --> [*]: Close
+			Failed --> [*]: Close
+		`).
+		Build()
+
+	h := New(t, sm).Start()
+
+	h.Run(
+		Send(event.WithName("Connect")),
+		Reject(event.WithName("Close"), statemachine.ErrUnhandledEvent),
+		Advance(5*time.Second),
+		Send(event.WithName("Connected")),
+		Advance(10*time.Second),
+		Send(event.WithName("Close")),
+	)
+
+	h.AssertPath("Idle", "Connecting", "Connected", "[*]")
+	h.AssertNever("Connecting", "Failed")
+	h.AssertCompleted()
+	h.AssertGolden("testdata/connected.golden")
+}
+
+func TestHarness_Timeout(t *testing.T) {
+	sm, _ := statemachine.NewBuilder().
+		WithInitialState(&statemachine.State{Name: "Idle"}).
+		AddState(&statemachine.State{
+			Name: "Connecting",
+			OnEnter: func(ctx context.Context, trigger statemachine.Trigger) error {
+				return nil
+			},
+		}).
+		AddState(&statemachine.State{Name: "Connected"}).
+		AddState(&statemachine.State{Name: "Failed"}).
+		RegisterAction("dial", func(ctx context.Context) (action.Result, error) {
+			return action.Nothing()
+		}).
+		FromGraph(`
+			[*] --> Idle
+			Idle --> Connecting: Connect / dial
+			Connecting --> Connected: Connected
+			Connecting --> Failed: after 10s
+			Connected --> [*]: Close
+			Failed --> [*]: Close
+		`).
+		Build()
+
+	h := New(t, sm).Start()
+
+	h.Run(
+		Send(event.WithName("Connect")),
+		Advance(10*time.Second),
+	)
+
+	h.AssertState("Failed")
+	h.AssertPath("Idle", "Connecting", "Failed")
+	h.AssertGolden("testdata/timeout.golden")
+}
@@ -0,0 +1,103 @@
+package statemachine
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// ErrSnapshotNotFound is returned by a Store when there is no snapshot for the requested id.
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// Store persists state machine snapshots, keyed by the state machine id.
+type Store interface {
+	Save(ctx context.Context, snapshot *Snapshot) error
+	// Load returns ErrSnapshotNotFound (possibly wrapped) when there is no snapshot for the id.
+	Load(ctx context.Context, id string) (*Snapshot, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// FileStore is a Store keeping each snapshot as a JSON file in a directory.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore creates a FileStore on the given directory, creating it if needed.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+// Save writes the snapshot to a temporary file first, replacing the previous one only once fully written.
+func (s *FileStore) Save(ctx context.Context, snapshot *Snapshot) error {
+	if snapshot.ID == "" {
+		return errors.New("snapshot has no id")
+	}
+
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".snapshot-*")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path(snapshot.ID))
+}
+
+func (s *FileStore) Load(ctx context.Context, id string) (*Snapshot, error) {
+	data, err := os.ReadFile(s.path(id))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotNotFound, id)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &Snapshot{}
+
+	if err = json.Unmarshal(data, snapshot); err != nil {
+		return nil, fmt.Errorf("corrupted snapshot %s: %w", id, err)
+	}
+
+	return snapshot, nil
+}
+
+func (s *FileStore) Delete(ctx context.Context, id string) error {
+	err := os.Remove(s.path(id))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// path returns the file of a snapshot, the id is escaped so it cannot point outside the directory.
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, url.PathEscape(id)+".json")
+}
@@ -0,0 +1,170 @@
+package statemachine
+
+import (
+	"fmt"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"strings"
+	"time"
+)
+
+// timedEventPrefix starts the event name of timed transitions, e.g. `after 10s`, which is also their graph label.
+const timedEventPrefix = "after "
+
+// Timeout is the event triggering a timed transition, raised once a state was active for the given duration.
+type Timeout struct {
+	State string
+	After time.Duration
+	token uint64
+}
+
+func (t *Timeout) EventName() string {
+	return timedEventName(t.After)
+}
+
+func timedEventName(after time.Duration) string {
+	return timedEventPrefix + after.String()
+}
+
+// parseTimedEventName parses `after 10s`, ok is false for any other event name.
+func parseTimedEventName(name string) (after time.Duration, ok bool, err error) {
+	if !strings.HasPrefix(name, timedEventPrefix) {
+		return 0, false, nil
+	}
+
+	after, err = time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(name, timedEventPrefix)))
+
+	if err != nil {
+		return 0, true, fmt.Errorf("invalid timed transition %s: %w", name, err)
+	}
+
+	if after <= 0 {
+		return 0, true, fmt.Errorf("invalid timed transition %s: duration must be positive", name)
+	}
+
+	return after, true, nil
+}
+
+type timerKey struct {
+	node  *Node
+	after time.Duration
+}
+
+type armedTimer struct {
+	timer Timer
+	token uint64
+}
+
+// AddTimedTransition adds a transition taken once the source state was active for the given duration, the timer
+// starts when the state is entered and is cancelled when it is left.
+func (sm *StateMachine) AddTimedTransition(fromStateName string, after time.Duration, toStateName string, opts ...TransitionOption) error {
+	if after <= 0 {
+		return fmt.Errorf("invalid timed transition from %s: duration must be positive", fromStateName)
+	}
+
+	return sm.addTransition(fromStateName, timedEventName(after), toStateName, opts...)
+}
+
+// armTimers starts the timers of the timed transitions of a state being entered.
+func (sm *StateMachine) armTimers(node *Node) {
+	for eventName, transitions := range node.Transitions {
+		if len(transitions) == 0 || !strings.HasPrefix(eventName, timedEventPrefix) {
+			continue
+		}
+
+		after, _, _ := parseTimedEventName(eventName)
+		key := timerKey{node: node, after: after}
+
+		sm.mu.Lock()
+
+		if armed, ok := sm.timers[key]; ok {
+			armed.timer.Stop()
+		}
+
+		sm.timerSeq++
+		timeout := &Timeout{State: node.State.Name, After: after, token: sm.timerSeq}
+
+		sm.timers[key] = &armedTimer{
+			token: timeout.token,
+			timer: sm.clock.AfterFunc(after, func() {
+				if err := sm.TriggerEvent(timeout); err != nil {
+					sm.l.Warn("Timed transition failed", "state", timeout.State, "after", timeout.After, "reason", err)
+				}
+			}),
+		}
+
+		sm.mu.Unlock()
+	}
+}
+
+// syncTimers makes the armed timers match the active states, after a transition, a failed one or a restore: the
+// timers of the states no longer active are cancelled, and the ones of the active states that are not armed are
+// (re)started.
+func (sm *StateMachine) syncTimers() {
+	active := map[*Node]bool{}
+
+	sm.mu.Lock()
+	if !sm.completed {
+		for _, leaf := range sm.leaves {
+			for n := leaf; n != nil; n = n.Parent {
+				active[n] = true
+			}
+		}
+	}
+
+	armed := map[*Node]bool{}
+	for key, t := range sm.timers {
+		if !active[key.node] {
+			t.timer.Stop()
+			delete(sm.timers, key)
+		} else {
+			armed[key.node] = true
+		}
+	}
+	sm.mu.Unlock()
+
+	for n := range active {
+		if !armed[n] {
+			sm.armTimers(n)
+		}
+	}
+}
+
+// processTimeout takes the timed transition of the state that armed the timer, unless the timer was cancelled in the
+// meantime (the event was already queued when its state was left).
+func (sm *StateMachine) processTimeout(timeout *Timeout) error {
+	node := sm.nodes[timeout.State]
+	key := timerKey{node: node, after: timeout.After}
+
+	sm.mu.Lock()
+	armed, ok := sm.timers[key]
+	if ok && armed.token == timeout.token {
+		delete(sm.timers, key)
+	}
+	sm.mu.Unlock()
+
+	if !ok || armed.token != timeout.token {
+		return nil
+	}
+
+	var e event.Event = timeout
+	leaf := sm.activeLeafUnder(node)
+	eventName := timeout.EventName()
+
+	if leaf == nil {
+		return nil
+	}
+
+	for _, t := range node.Transitions[eventName] {
+		trigger := Trigger{
+			FromState: leaf.State,
+			ToState:   t.To.State,
+			Event:     &e,
+		}
+
+		if t.Guard == nil || t.Guard(sm.context, trigger) {
+			return sm.executeTransition(node, leaf, &trigger, &t)
+		}
+	}
+
+	return fmt.Errorf("%w: state %s, event %s", ErrGuardRejected, timeout.State, eventName)
+}
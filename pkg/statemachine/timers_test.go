@@ -0,0 +1,210 @@
+package statemachine
+
+import (
+	"context"
+	"errors"
+	"github.com/a-inacio/edt-go/pkg/action"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeTimer struct {
+	clock   *fakeClock
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	wasPending := !t.stopped
+	t.stopped = true
+
+	return wasPending
+}
+
+// fakeClock only moves forward when advanced, due timers are fired by Advance, in the calling goroutine.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t := &fakeTimer{clock: c, at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+
+	return t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+
+	var due []*fakeTimer
+	for _, t := range c.timers {
+		if !t.stopped && !t.at.After(c.now) {
+			t.stopped = true
+			due = append(due, t)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, t := range due {
+		t.f()
+	}
+}
+
+func TestStateMachine_TimedTransition(t *testing.T) {
+	clock := &fakeClock{}
+
+	sm, err := NewBuilder().
+		WithInitialState(&State{Name: "Connecting"}).
+		AddState(&State{Name: "Connected"}).
+		AddState(&State{Name: "Failed"}).
+		FromGraph(`
+stateDiagram-v2
+    [*] --> Connecting
+    Connecting --> Connected : Connect
+    Connecting --> Failed : after 10s
+`).
+		WithClock(clock).
+		Build()
+
+	if err != nil {
+		t.Fatalf("Creating the state machine should not have failed: %v", err)
+	}
+
+	sm.Start()
+
+	clock.Advance(9 * time.Second)
+
+	if sm.CurrentState() != "Connecting" {
+		t.Errorf("Connecting State, should not have timed out yet, got %s", sm.CurrentState())
+	}
+
+	clock.Advance(time.Second)
+
+	if sm.CurrentState() != "Failed" {
+		t.Errorf("Connecting State, should have timed out to Failed, got %s", sm.CurrentState())
+	}
+
+	history := sm.History()
+	if last := history[len(history)-1]; last.Event != "after 10s" || last.Duration != 10*time.Second {
+		t.Errorf("The timed transition should have been recorded, got %+v", last)
+	}
+}
+
+func TestStateMachine_TimedTransition_CancelledOnExit(t *testing.T) {
+	clock := &fakeClock{}
+
+	sm, _ := NewStateMachine(&State{Name: "Connecting"}, context.Background())
+	sm.SetClock(clock)
+	sm.AddState(&State{Name: "Connected"})
+	sm.AddState(&State{Name: "Failed"})
+	sm.AddTransition("Connecting", event.WithName("Connect"), "Connected")
+	sm.AddTimedTransition("Connecting", 10*time.Second, "Failed")
+
+	sm.Start()
+	sm.TriggerEvent(event.WithName("Connect"))
+
+	clock.Advance(time.Minute)
+
+	if sm.CurrentState() != "Connected" {
+		t.Errorf("Leaving Connecting State, should have cancelled its timer, got %s", sm.CurrentState())
+	}
+
+	if len(sm.timers) != 0 {
+		t.Errorf("No timer should be left armed, got %d", len(sm.timers))
+	}
+}
+
+func TestStateMachine_TimedTransition_KeptOnFailedTransition(t *testing.T) {
+	clock := &fakeClock{}
+
+	sm, _ := NewStateMachine(&State{Name: "Connecting"}, context.Background())
+	sm.SetClock(clock)
+	sm.AddState(&State{Name: "Connected"})
+	sm.AddState(&State{Name: "Failed"})
+	sm.AddTransition("Connecting", event.WithName("Connect"), "Connected", WithAction(func(ctx context.Context) (action.Result, error) {
+		return nil, errors.New("handshake failed")
+	}))
+	sm.AddTimedTransition("Connecting", 10*time.Second, "Failed")
+
+	sm.Start()
+
+	clock.Advance(5 * time.Second)
+
+	if err := sm.TriggerEvent(event.WithName("Connect")); err == nil {
+		t.Fatal("Connect should have failed")
+	}
+
+	clock.Advance(5 * time.Second)
+
+	if sm.CurrentState() != "Failed" {
+		t.Errorf("Connecting State, a failed transition should not have restarted its timer, got %s", sm.CurrentState())
+	}
+}
+
+func TestStateMachine_TimedTransition_Composite(t *testing.T) {
+	clock := &fakeClock{}
+
+	sm, _ := NewStateMachine(&State{Name: "Session"}, context.Background())
+	sm.SetClock(clock)
+	sm.AddSubState("Session", &State{Name: "Idle"})
+	sm.AddSubState("Session", &State{Name: "Busy"})
+	sm.AddState(&State{Name: "Expired"})
+	sm.AddTransition("Idle", event.WithName("Work"), "Busy")
+	sm.AddTransition("Busy", event.WithName("Rest"), "Idle")
+	sm.AddTimedTransition("Session", time.Hour, "Expired")
+	sm.AddTimedTransition("Idle", 5*time.Minute, "Idle")
+
+	sm.Start()
+
+	// Re-entering Idle restarts its own timer but not the one of the enclosing Session
+	clock.Advance(4 * time.Minute)
+	sm.TriggerEvent(event.WithName("Work"))
+	clock.Advance(time.Minute)
+	sm.TriggerEvent(event.WithName("Rest"))
+	clock.Advance(4 * time.Minute)
+
+	if sm.CurrentState() != "Idle" {
+		t.Errorf("Idle State, should still be active, got %s", sm.CurrentState())
+	}
+
+	clock.Advance(51 * time.Minute)
+
+	if sm.CurrentState() != "Expired" {
+		t.Errorf("Session State, should have expired, got %s", sm.CurrentState())
+	}
+}
+
+func TestStateMachineBuilder_TimedTransition_InvalidLabel(t *testing.T) {
+	_, err := NewBuilder().
+		WithInitialState(&State{Name: "A"}).
+		AddState(&State{Name: "B"}).
+		FromGraph(`
+stateDiagram-v2
+    [*] --> A
+    A --> B : after soon
+`).
+		Build()
+
+	if err == nil {
+		t.Error("An invalid duration should have failed the build")
+	}
+}
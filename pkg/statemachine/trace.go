@@ -0,0 +1,123 @@
+package statemachine
+
+import (
+	"context"
+	"fmt"
+)
+
+// TraceKind tells what a TraceStep is about.
+type TraceKind int
+
+const (
+	// TraceExit is a state being left.
+	TraceExit TraceKind = iota
+	// TraceEnter is a state being entered.
+	TraceEnter
+	// TraceHook is a hook of a state that ran: OnBefore, OnEnter or OnAfter.
+	TraceHook
+	// TraceAction is the action of a transition that ran.
+	TraceAction
+	// TraceTransition is a transition that was taken, or failed. An internal transition taken only traces its action.
+	TraceTransition
+	// TraceRejected is an event that could not be processed, see TransitionRejected.
+	TraceRejected
+)
+
+// TraceStep is a step of the execution of a state machine, see SetTracer.
+type TraceStep struct {
+	Kind TraceKind
+	// State is the state exited, entered, whose hook ran or that rejected the event.
+	State string
+	// Hook is the name of the hook that ran.
+	Hook string
+	// From is empty when starting, written as [*].
+	From  string
+	To    string
+	Event string
+	Err   error
+}
+
+func (s TraceStep) String() string {
+	var line string
+
+	from := s.From
+	if from == "" {
+		from = FinalState
+	}
+
+	switch s.Kind {
+	case TraceExit:
+		line = fmt.Sprintf("exit %s", s.State)
+	case TraceEnter:
+		line = fmt.Sprintf("enter %s", s.State)
+	case TraceHook:
+		line = fmt.Sprintf("%s %s", s.Hook, s.State)
+	case TraceAction:
+		line = fmt.Sprintf("action %s -> %s", from, s.To)
+	case TraceTransition:
+		line = fmt.Sprintf("transition %s -> %s", from, s.To)
+	case TraceRejected:
+		line = fmt.Sprintf("rejected in %s", s.State)
+	}
+
+	if s.Event != "" && (s.Kind == TraceTransition || s.Kind == TraceRejected) {
+		line = fmt.Sprintf("%s on %s", line, s.Event)
+	}
+
+	if s.Err != nil {
+		line = fmt.Sprintf("%s: %v", line, s.Err)
+	}
+
+	return line
+}
+
+// SetTracer makes the state machine report every step of its execution, synchronously, from the goroutine
+// processing the events, e.g. to record it in tests (see the statemachinetest package).
+func (sm *StateMachine) SetTracer(tracer func(step TraceStep)) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.tracer = tracer
+}
+
+func (sm *StateMachine) trace(step TraceStep) {
+	sm.mu.Lock()
+	tracer := sm.tracer
+	sm.mu.Unlock()
+
+	if tracer != nil {
+		tracer(step)
+	}
+}
+
+// runHook runs a hook of a state, if defined, and traces it.
+func (sm *StateMachine) runHook(n *Node, name string, hook func(ctx context.Context, trigger Trigger) error, trigger *Trigger) error {
+	if hook == nil {
+		return nil
+	}
+
+	err := hook(sm.context, *trigger)
+	sm.trace(TraceStep{Kind: TraceHook, State: n.State.Name, Hook: name, Err: err})
+
+	if err != nil {
+		return fmt.Errorf("state %s %s: %w", n.State.Name, name, err)
+	}
+
+	return nil
+}
+
+// traceAction traces the action of a transition that ran.
+func (sm *StateMachine) traceAction(trigger *Trigger, transition *Transition, err error) {
+	from := ""
+	if trigger.FromState != nil {
+		from = trigger.FromState.Name
+	}
+
+	sm.trace(TraceStep{Kind: TraceAction, From: from, To: transition.To.State.Name, Event: transition.EventName, Err: err})
+}
+
+// reject reports an event that could not be processed.
+func (sm *StateMachine) reject(eventName string, err error) {
+	sm.trace(TraceStep{Kind: TraceRejected, State: sm.current, Event: eventName, Err: err})
+	sm.publish(TransitionRejected{Machine: sm.id, State: sm.current, Event: eventName, Reason: err})
+}
@@ -0,0 +1,253 @@
+package statemachine
+
+import (
+	"context"
+	"fmt"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"reflect"
+	"time"
+)
+
+// TypedTrigger is the Trigger of a Typed state machine.
+type TypedTrigger[S comparable, E any] struct {
+	// Event is the triggering event, the zero value when entering the initial state and for completion and timed
+	// transitions (see HasEvent).
+	Event    E
+	HasEvent bool
+	// From is the zero value when entering the initial state.
+	From S
+	To   S
+}
+
+// TypedState is a state of a Typed state machine and its hooks, any hook returning an error fails the transition.
+type TypedState[S comparable, E any] struct {
+	State    S
+	OnBefore func(ctx context.Context, trigger TypedTrigger[S, E]) error
+	OnEnter  func(ctx context.Context, trigger TypedTrigger[S, E]) error
+	OnAfter  func(ctx context.Context, trigger TypedTrigger[S, E]) error
+}
+
+// TypedTransitionOption customizes a transition of a Typed state machine when it is added.
+type TypedTransitionOption[S comparable, E any] func(m *Typed[S, E]) TransitionOption
+
+// WithTypedGuard makes the transition conditional, see WithGuard.
+func WithTypedGuard[S comparable, E any](guard func(ctx context.Context, trigger TypedTrigger[S, E]) bool) TypedTransitionOption[S, E] {
+	return func(m *Typed[S, E]) TransitionOption {
+		return WithGuard(func(ctx context.Context, trigger Trigger) bool {
+			return guard(ctx, m.typedTrigger(trigger))
+		})
+	}
+}
+
+// WithTypedAction defines an effect of the transition, executed after leaving the source state and before entering
+// the target state.
+func WithTypedAction[S comparable, E any](a func(ctx context.Context, trigger TypedTrigger[S, E]) error) TypedTransitionOption[S, E] {
+	return func(m *Typed[S, E]) TransitionOption {
+		return func(t *Transition) {
+			t.effect = func(ctx context.Context, trigger Trigger) error {
+				return a(ctx, m.typedTrigger(trigger))
+			}
+		}
+	}
+}
+
+// typedEvent carries a typed event through the StateMachine, under the name of the event.
+type typedEvent[E any] struct {
+	name  string
+	value E
+}
+
+func (e *typedEvent[E]) EventName() string {
+	return e.name
+}
+
+// Typed is a state machine whose states and events are Go types, e.g. enum constants, instead of names.
+// It shares its execution engine with StateMachine: states are named with fmt.Sprint (so a String method is used when
+// there is one) as are events of basic types, while events of other types are named after their type (see
+// event.GetName).
+type Typed[S comparable, E any] struct {
+	sm     *StateMachine
+	states map[string]S
+}
+
+// NewTyped creates a Typed state machine.
+func NewTyped[S comparable, E any](initialState TypedState[S, E], ctx context.Context) (*Typed[S, E], error) {
+	m := &Typed[S, E]{
+		states: map[string]S{},
+	}
+
+	state, err := m.state(initialState)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sm, err := NewStateMachine(state, ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	m.sm = sm
+
+	return m, nil
+}
+
+// Machine returns the underlying StateMachine, e.g. to export or snapshot it.
+func (m *Typed[S, E]) Machine() *StateMachine {
+	return m.sm
+}
+
+func (m *Typed[S, E]) AddState(state TypedState[S, E]) error {
+	s, err := m.state(state)
+
+	if err != nil {
+		return err
+	}
+
+	return m.sm.AddState(s)
+}
+
+// AddSubState adds a state nested inside a composite state, see StateMachine.AddSubState.
+func (m *Typed[S, E]) AddSubState(parent S, state TypedState[S, E]) error {
+	s, err := m.state(state)
+
+	if err != nil {
+		return err
+	}
+
+	return m.sm.AddSubState(m.stateName(parent), s)
+}
+
+func (m *Typed[S, E]) AddTransition(from S, e E, to S, opts ...TypedTransitionOption[S, E]) error {
+	return m.sm.AddTransition(m.stateName(from), m.event(e), m.stateName(to), m.options(opts)...)
+}
+
+// AddInternalTransition adds a transition that runs its action without leaving the state, see
+// StateMachine.AddInternalTransition.
+func (m *Typed[S, E]) AddInternalTransition(state S, e E, opts ...TypedTransitionOption[S, E]) error {
+	return m.sm.AddInternalTransition(m.stateName(state), m.event(e), m.options(opts)...)
+}
+
+// AddCompletionTransition adds a transition taken as soon as the source state is entered, see
+// StateMachine.AddCompletionTransition. To complete the state machine, see AddFinalTransition.
+func (m *Typed[S, E]) AddCompletionTransition(from S, to S, opts ...TypedTransitionOption[S, E]) error {
+	return m.sm.AddCompletionTransition(m.stateName(from), m.stateName(to), m.options(opts)...)
+}
+
+// AddFinalTransition adds a transition to the final state, on an event.
+func (m *Typed[S, E]) AddFinalTransition(from S, e E, opts ...TypedTransitionOption[S, E]) error {
+	return m.sm.AddTransition(m.stateName(from), m.event(e), FinalState, m.options(opts)...)
+}
+
+// AddTimedTransition adds a transition taken once the source state was active for the given duration, see
+// StateMachine.AddTimedTransition.
+func (m *Typed[S, E]) AddTimedTransition(from S, after time.Duration, to S, opts ...TypedTransitionOption[S, E]) error {
+	return m.sm.AddTimedTransition(m.stateName(from), after, m.stateName(to), m.options(opts)...)
+}
+
+func (m *Typed[S, E]) Start() error {
+	return m.sm.Start()
+}
+
+// TriggerEvent processes an event, see StateMachine.TriggerEvent.
+func (m *Typed[S, E]) TriggerEvent(e E) error {
+	return m.sm.TriggerEvent(m.event(e))
+}
+
+// CurrentState returns the innermost active state, ok is false if the state machine is not running.
+func (m *Typed[S, E]) CurrentState() (state S, ok bool) {
+	state, ok = m.states[m.sm.CurrentState()]
+	return state, ok
+}
+
+// IsInState tells if the given state is active, see StateMachine.IsInState.
+func (m *Typed[S, E]) IsInState(state S) bool {
+	return m.sm.IsInState(m.stateName(state))
+}
+
+func (m *Typed[S, E]) IsCompleted() bool {
+	return m.sm.IsCompleted()
+}
+
+func (m *Typed[S, E]) Done() <-chan struct{} {
+	return m.sm.Done()
+}
+
+func (m *Typed[S, E]) stateName(state S) string {
+	return fmt.Sprint(state)
+}
+
+// eventName names events of basic types (e.g. enum constants) by their value, other events by their type.
+func (m *Typed[S, E]) eventName(e E) string {
+	switch reflect.ValueOf(&e).Elem().Kind() {
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.String:
+		return fmt.Sprint(e)
+	default:
+		return event.GetName(e)
+	}
+}
+
+func (m *Typed[S, E]) event(e E) *typedEvent[E] {
+	return &typedEvent[E]{name: m.eventName(e), value: e}
+}
+
+// state registers a state, making sure its name is not shared with another state, and adapts its hooks.
+func (m *Typed[S, E]) state(state TypedState[S, E]) (*State, error) {
+	name := m.stateName(state.State)
+
+	if existing, ok := m.states[name]; ok && existing != state.State {
+		return nil, fmt.Errorf("states %v and %v share the same name %s", existing, state.State, name)
+	}
+
+	m.states[name] = state.State
+
+	return &State{
+		Name:     name,
+		OnBefore: m.hook(state.OnBefore),
+		OnEnter:  m.hook(state.OnEnter),
+		OnAfter:  m.hook(state.OnAfter),
+	}, nil
+}
+
+func (m *Typed[S, E]) hook(hook func(ctx context.Context, trigger TypedTrigger[S, E]) error) func(ctx context.Context, trigger Trigger) error {
+	if hook == nil {
+		return nil
+	}
+
+	return func(ctx context.Context, trigger Trigger) error {
+		return hook(ctx, m.typedTrigger(trigger))
+	}
+}
+
+func (m *Typed[S, E]) options(opts []TypedTransitionOption[S, E]) []TransitionOption {
+	options := make([]TransitionOption, len(opts))
+
+	for i, opt := range opts {
+		options[i] = opt(m)
+	}
+
+	return options
+}
+
+func (m *Typed[S, E]) typedTrigger(trigger Trigger) TypedTrigger[S, E] {
+	typed := TypedTrigger[S, E]{}
+
+	if trigger.Event != nil {
+		if e, ok := (*trigger.Event).(*typedEvent[E]); ok {
+			typed.Event = e.value
+			typed.HasEvent = true
+		}
+	}
+
+	if trigger.FromState != nil {
+		typed.From = m.states[trigger.FromState.Name]
+	}
+
+	if trigger.ToState != nil {
+		typed.To = m.states[trigger.ToState.Name]
+	}
+
+	return typed
+}
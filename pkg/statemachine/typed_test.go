@@ -0,0 +1,132 @@
+package statemachine
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type orderState int
+
+const (
+	orderCreated orderState = iota
+	orderPaid
+	orderShipped
+)
+
+func (s orderState) String() string {
+	return [...]string{"Created", "Paid", "Shipped"}[s]
+}
+
+type orderEvent interface{}
+
+type paymentReceived struct {
+	Amount int
+}
+
+type shipmentSent struct {
+	Carrier string
+}
+
+func TestTyped_TriggerEvent(t *testing.T) {
+	var paid int
+	var carrier string
+
+	sm, err := NewTyped[orderState, orderEvent](TypedState[orderState, orderEvent]{State: orderCreated}, context.Background())
+
+	if err != nil {
+		t.Fatalf("Creating the state machine should not have failed: %v", err)
+	}
+
+	sm.AddState(TypedState[orderState, orderEvent]{
+		State: orderPaid,
+		OnEnter: func(ctx context.Context, trigger TypedTrigger[orderState, orderEvent]) error {
+			paid = trigger.Event.(paymentReceived).Amount
+
+			if trigger.From != orderCreated {
+				t.Errorf("Paid State, should have been entered from Created, got %v", trigger.From)
+			}
+
+			return nil
+		},
+	})
+	sm.AddState(TypedState[orderState, orderEvent]{State: orderShipped})
+
+	sm.AddTransition(orderCreated, paymentReceived{}, orderPaid,
+		WithTypedGuard(func(ctx context.Context, trigger TypedTrigger[orderState, orderEvent]) bool {
+			return trigger.Event.(paymentReceived).Amount > 0
+		}))
+	sm.AddTransition(orderPaid, shipmentSent{}, orderShipped,
+		WithTypedAction(func(ctx context.Context, trigger TypedTrigger[orderState, orderEvent]) error {
+			carrier = trigger.Event.(shipmentSent).Carrier
+			return nil
+		}))
+
+	sm.Start()
+
+	if err = sm.TriggerEvent(paymentReceived{Amount: 0}); !errors.Is(err, ErrGuardRejected) {
+		t.Errorf("A payment without amount should have been rejected, got %v", err)
+	}
+
+	sm.TriggerEvent(paymentReceived{Amount: 42})
+	sm.TriggerEvent(shipmentSent{Carrier: "Post"})
+
+	if state, ok := sm.CurrentState(); !ok || state != orderShipped {
+		t.Errorf("The order should have been shipped, got %v", state)
+	}
+
+	if paid != 42 || carrier != "Post" {
+		t.Errorf("Hooks should have received the events, got %d and %s", paid, carrier)
+	}
+
+	if sm.Machine().CurrentState() != "Shipped" {
+		t.Errorf("States should be named after their String method, got %s", sm.Machine().CurrentState())
+	}
+}
+
+type light int
+
+const (
+	lightOff light = iota
+	lightOn
+)
+
+type switchEvent string
+
+const (
+	switchToggle switchEvent = "Toggle"
+	switchBreak  switchEvent = "Break"
+)
+
+func TestTyped_EnumEvents(t *testing.T) {
+	sm, _ := NewTyped[light, switchEvent](TypedState[light, switchEvent]{State: lightOff}, context.Background())
+	sm.AddState(TypedState[light, switchEvent]{State: lightOn})
+	sm.AddTransition(lightOff, switchToggle, lightOn)
+	sm.AddTransition(lightOn, switchToggle, lightOff)
+	sm.AddFinalTransition(lightOn, switchBreak)
+
+	sm.Start()
+	sm.TriggerEvent(switchToggle)
+
+	if !sm.IsInState(lightOn) {
+		t.Error("On State, should be active")
+	}
+
+	if err := sm.TriggerEvent(switchBreak); err != nil {
+		t.Errorf("Break should have been handled, got %v", err)
+	}
+
+	if !sm.IsCompleted() {
+		t.Error("The state machine should have completed")
+	}
+}
+
+func TestTyped_DuplicateStateNames(t *testing.T) {
+	type named int
+
+	sm, _ := NewTyped[named, string](TypedState[named, string]{State: 1}, context.Background())
+
+	if err := sm.AddState(TypedState[named, string]{State: 1}); err == nil {
+		t.Error("Adding the same state twice should have failed")
+	}
+}
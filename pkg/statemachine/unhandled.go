@@ -0,0 +1,150 @@
+package statemachine
+
+import (
+	"context"
+	"fmt"
+	"github.com/a-inacio/edt-go/pkg/event"
+)
+
+// UnhandledPolicy defines what happens to an event no active state has a transition for.
+type UnhandledPolicy int
+
+const (
+	// UnhandledDefault applies the policy of the enclosing state, or the one of the state machine, UnhandledError
+	// when none is set.
+	UnhandledDefault UnhandledPolicy = iota
+	// UnhandledError returns an error wrapping ErrUnhandledEvent to the caller.
+	UnhandledError
+	// UnhandledIgnore discards the event silently.
+	UnhandledIgnore
+	// UnhandledFallback hands the event to the fallback handler, whose error is returned to the caller.
+	UnhandledFallback
+	// UnhandledDefer keeps the event (UML deferred event) and replays it, in order, as soon as a state having a
+	// transition for it is entered. Deferred events are dropped when the state machine completes.
+	UnhandledDefer
+)
+
+// FallbackHandler handles the events routed by the UnhandledFallback policy, the trigger holds the event and the
+// innermost active state (FromState).
+type FallbackHandler func(ctx context.Context, trigger Trigger) error
+
+// SetUnhandledPolicy defines the policy for the events no active state has a transition for, unless the active state
+// (or an enclosing one) has its own.
+func (sm *StateMachine) SetUnhandledPolicy(policy UnhandledPolicy) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.unhandled = policy
+}
+
+// SetStateUnhandledPolicy defines the policy for the events not handled while the state, or any state nested inside
+// it, is active.
+func (sm *StateMachine) SetStateUnhandledPolicy(stateName string, policy UnhandledPolicy) error {
+	if sm.shared {
+		return ErrSharedDefinition
+	}
+
+	node, ok := sm.nodes[stateName]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownState, stateName)
+	}
+
+	node.Unhandled = policy
+
+	return nil
+}
+
+// SetFallbackHandler defines the handler of the events routed by the UnhandledFallback policy.
+func (sm *StateMachine) SetFallbackHandler(handler FallbackHandler) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.fallback = handler
+}
+
+// unhandledPolicy resolves the policy for an unhandled event: the first active region whose innermost active state,
+// or an enclosing one, has a policy decides, otherwise the one of the state machine does.
+func (sm *StateMachine) unhandledPolicy() (UnhandledPolicy, *Node) {
+	leaves := sm.activeLeaves()
+
+	for _, leaf := range leaves {
+		for n := leaf; n != nil; n = n.Parent {
+			if n.Unhandled != UnhandledDefault {
+				return n.Unhandled, leaf
+			}
+		}
+	}
+
+	sm.mu.Lock()
+	policy := sm.unhandled
+	sm.mu.Unlock()
+
+	if policy == UnhandledDefault {
+		policy = UnhandledError
+	}
+
+	var leaf *Node
+	if len(leaves) > 0 {
+		leaf = leaves[0]
+	}
+
+	return policy, leaf
+}
+
+// handleUnhandled applies the unhandled policy to an event no active state has a transition for.
+func (sm *StateMachine) handleUnhandled(e event.Event, err error) error {
+	policy, leaf := sm.unhandledPolicy()
+
+	switch policy {
+	case UnhandledIgnore:
+		sm.l.Debug("Unhandled event ignored", "event", event.GetName(e), "state", sm.current)
+		return nil
+	case UnhandledDefer:
+		sm.l.Debug("Unhandled event deferred", "event", event.GetName(e), "state", sm.current)
+		sm.deferred = append(sm.deferred, e)
+		return nil
+	case UnhandledFallback:
+		sm.mu.Lock()
+		fallback := sm.fallback
+		sm.mu.Unlock()
+
+		if fallback == nil {
+			return fmt.Errorf("%w (no fallback handler)", err)
+		}
+
+		return fallback(sm.context, Trigger{Event: &e, FromState: leaf.State})
+	}
+
+	sm.reject(event.GetName(e), err)
+
+	return err
+}
+
+// replayDeferred processes, in order, the deferred events a now active state has a transition for, until none is
+// left, it must only be called by the goroutine processing the mailbox. Events whose guards do not pass yet stay
+// deferred, the guards of the others are evaluated once, the transitions selected being the ones taken.
+func (sm *StateMachine) replayDeferred() {
+	for !sm.completed {
+		i := -1
+		var selected []selectedTransition
+
+		for j, e := range sm.deferred {
+			var err error
+			if selected, err = sm.selectTransitions(event.GetName(e), &e); err == nil {
+				i = j
+				break
+			}
+		}
+
+		if i < 0 {
+			return
+		}
+
+		e := sm.deferred[i]
+		sm.deferred = append(sm.deferred[:i:i], sm.deferred[i+1:]...)
+
+		if err := sm.executeSelected(e, selected); err != nil {
+			sm.l.Warn("Deferred event failed", "event", event.GetName(e), "reason", err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package statemachine
+
+import (
+	"context"
+	"errors"
+	"github.com/a-inacio/edt-go/pkg/action"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"testing"
+)
+
+const uploadGraph = `
+	[*] --> Idle
+	Idle --> Connecting: Connect
+	Connecting --> Connected: Connected
+	Connected --> Idle: Upload
+`
+
+func TestStateMachine_Unhandled_Error(t *testing.T) {
+	sm, _ := NewBuilder().
+		WithInitialState(&State{Name: "Idle"}).
+		AddState(&State{Name: "Connecting"}).
+		AddState(&State{Name: "Connected"}).
+		FromGraph(uploadGraph).
+		Build()
+
+	sm.Start()
+
+	if err := sm.TriggerEvent(event.WithName("Upload")); !errors.Is(err, ErrUnhandledEvent) {
+		t.Errorf("Idle State, should not handle Upload, got %v", err)
+	}
+}
+
+func TestStateMachine_Unhandled_Ignore(t *testing.T) {
+	sm, _ := NewBuilder().
+		WithUnhandledPolicy(UnhandledError).
+		WithStateUnhandledPolicy("Idle", UnhandledIgnore).
+		WithInitialState(&State{Name: "Idle"}).
+		AddState(&State{Name: "Connecting"}).
+		AddState(&State{Name: "Connected"}).
+		FromGraph(uploadGraph).
+		Build()
+
+	sm.Start()
+
+	if err := sm.TriggerEvent(event.WithName("Upload")); err != nil {
+		t.Errorf("Idle State, should ignore Upload, got %v", err)
+	}
+
+	sm.TriggerEvent(event.WithName("Connect"))
+
+	if err := sm.TriggerEvent(event.WithName("Upload")); !errors.Is(err, ErrUnhandledEvent) {
+		t.Errorf("Connecting State, should not ignore Upload, got %v", err)
+	}
+}
+
+func TestStateMachine_Unhandled_Fallback(t *testing.T) {
+	var handled []string
+
+	sm, _ := NewBuilder().
+		WithUnhandledPolicy(UnhandledFallback).
+		WithFallbackHandler(func(ctx context.Context, trigger Trigger) error {
+			handled = append(handled, trigger.FromState.Name+":"+event.GetName(*trigger.Event))
+			return nil
+		}).
+		WithInitialState(&State{Name: "Idle"}).
+		AddState(&State{Name: "Connecting"}).
+		AddState(&State{Name: "Connected"}).
+		FromGraph(uploadGraph).
+		Build()
+
+	sm.Start()
+
+	if err := sm.TriggerEvent(event.WithName("Upload")); err != nil {
+		t.Errorf("The fallback handler should have handled Upload, got %v", err)
+	}
+
+	if len(handled) != 1 || handled[0] != "Idle:Upload" {
+		t.Errorf("The fallback handler should have received the event, got %v", handled)
+	}
+}
+
+func TestStateMachine_Unhandled_Defer(t *testing.T) {
+	var uploaded int
+
+	sm, _ := NewStateMachine(&State{Name: "Idle"}, context.Background())
+	sm.AddState(&State{Name: "Connecting"})
+	sm.AddState(&State{Name: "Connected"})
+	sm.AddTransition("Idle", event.WithName("Connect"), "Connecting")
+	sm.AddTransition("Connecting", event.WithName("Connected"), "Connected")
+	sm.AddTransition("Connected", event.WithName("Upload"), "Connected", WithAction(func(ctx context.Context) (action.Result, error) {
+		uploaded++
+		return action.Nothing()
+	}))
+	sm.SetStateUnhandledPolicy("Connecting", UnhandledDefer)
+
+	sm.Start()
+	sm.TriggerEvent(event.WithName("Connect"))
+
+	for i := 0; i < 2; i++ {
+		if err := sm.TriggerEvent(event.WithName("Upload")); err != nil {
+			t.Errorf("Connecting State, should defer Upload, got %v", err)
+		}
+	}
+
+	if uploaded != 0 {
+		t.Error("Deferred events should not have been processed yet")
+	}
+
+	sm.TriggerEvent(event.WithName("Connected"))
+
+	if uploaded != 2 {
+		t.Errorf("Deferred events should have been replayed once Connected, got %d", uploaded)
+	}
+}
+
+func TestStateMachine_Unhandled_Defer_Guarded(t *testing.T) {
+	ready := false
+	uploaded := 0
+	guarded := 0
+
+	sm, _ := NewStateMachine(&State{Name: "Idle"}, context.Background())
+	sm.AddState(&State{Name: "Connecting"})
+	sm.AddState(&State{Name: "Connected"})
+	sm.AddTransition("Idle", event.WithName("Connect"), "Connecting")
+	sm.AddTransition("Connecting", event.WithName("Connected"), "Connected")
+	sm.AddInternalTransition("Connected", event.WithName("Refresh"))
+	sm.AddTransition("Connected", event.WithName("Upload"), "Connected",
+		WithGuard(func(ctx context.Context, trigger Trigger) bool {
+			guarded++
+			return ready
+		}),
+		WithAction(func(ctx context.Context) (action.Result, error) {
+			uploaded++
+			return action.Nothing()
+		}))
+	sm.SetStateUnhandledPolicy("Connecting", UnhandledDefer)
+
+	sm.Start()
+	sm.TriggerEvent(event.WithName("Connect"))
+	sm.TriggerEvent(event.WithName("Upload"))
+	sm.TriggerEvent(event.WithName("Connected"))
+
+	if uploaded != 0 {
+		t.Error("Connected State, the guard should have kept Upload deferred")
+	}
+
+	ready = true
+	sm.TriggerEvent(event.WithName("Refresh"))
+
+	if uploaded != 1 {
+		t.Errorf("Connected State, the deferred Upload should have been replayed once the guard passed, got %d", uploaded)
+	}
+
+	if guarded != 2 {
+		t.Errorf("Connected State, the guard should have been evaluated once per replay, got %d", guarded)
+	}
+}
@@ -0,0 +1,146 @@
+package statemachine
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrUnreachableState is reported for a state that can never be entered.
+var ErrUnreachableState = errors.New("unreachable state")
+
+// ErrDeadEndState is reported for a state, other than the final state, that has no way out once entered.
+var ErrDeadEndState = errors.New("state with no exit")
+
+// ErrUnusedEvent is reported for an event declared on the builder that no transition uses.
+var ErrUnusedEvent = errors.New("unused event")
+
+// ValidationError gathers every problem found in a state machine definition.
+type ValidationError struct {
+	Findings []error
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Findings))
+
+	for i, f := range e.Findings {
+		msgs[i] = f.Error()
+	}
+
+	return fmt.Sprintf("invalid state machine definition, %d problem(s): %s", len(e.Findings), strings.Join(msgs, "; "))
+}
+
+func (e *ValidationError) Unwrap() []error {
+	return e.Findings
+}
+
+// Has tells if any of the findings is the given error (e.g. ErrUnreachableState).
+func (e *ValidationError) Has(target error) bool {
+	for _, f := range e.Findings {
+		if errors.Is(f, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validate checks the structure of the state machine, reporting unreachable states, states with no exit and
+// transitions sharing the same guard. It returns a *ValidationError with all findings, nil if there are none.
+func (sm *StateMachine) Validate() error {
+	findings := sm.validate()
+
+	if len(findings) == 0 {
+		return nil
+	}
+
+	return &ValidationError{Findings: findings}
+}
+
+func (sm *StateMachine) validate() []error {
+	var findings []error
+
+	reached := sm.reachableNodes()
+
+	for _, name := range sm.order {
+		node := sm.nodes[name]
+
+		if !reached[node] {
+			findings = append(findings, fmt.Errorf("%w: %s", ErrUnreachableState, name))
+			continue
+		}
+
+		if (node.Type == ChildNode || node.Type == InitialNode) && !node.IsComposite() && !hasExit(node) {
+			findings = append(findings, fmt.Errorf("%w: %s", ErrDeadEndState, name))
+		}
+
+		for eventName, transitions := range node.Transitions {
+			guards := map[string]bool{}
+
+			for _, t := range transitions {
+				if t.GuardName == "" {
+					continue
+				}
+
+				if guards[t.GuardName] {
+					findings = append(findings, fmt.Errorf("%w: %s has several transitions on %s guarded by %s",
+						ErrNondeterministicTransition, name, eventName, t.GuardName))
+				}
+
+				guards[t.GuardName] = true
+			}
+		}
+	}
+
+	return findings
+}
+
+// reachableNodes returns the nodes that can be entered, starting from the initial state (and the error state).
+// Entering a state means entering its enclosing composite states and, for a composite state, its initial sub-state.
+func (sm *StateMachine) reachableNodes() map[*Node]bool {
+	reached := map[*Node]bool{}
+
+	var visit func(n *Node)
+	visit = func(n *Node) {
+		if n == nil || reached[n] {
+			return
+		}
+
+		reached[n] = true
+
+		visit(n.Parent)
+
+		for _, initial := range n.RegionInitials {
+			visit(initial)
+		}
+
+		for _, transitions := range n.Transitions {
+			for _, t := range transitions {
+				visit(t.To)
+			}
+		}
+	}
+
+	visit(sm.nodes[sm.initial])
+
+	if sm.errorState != "" {
+		visit(sm.nodes[sm.errorState])
+	}
+
+	return reached
+}
+
+// hasExit tells if a state, or one of its enclosing composite states, has transitions, internal ones excluded.
+func hasExit(node *Node) bool {
+	for n := node; n != nil; n = n.Parent {
+		for _, transitions := range n.Transitions {
+			for _, t := range transitions {
+				if t.Kind != InternalTransition {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,163 @@
+package statemachine
+
+import (
+	"context"
+	"errors"
+	"github.com/a-inacio/edt-go/pkg/event"
+	"github.com/a-inacio/edt-go/pkg/eventhub"
+	"testing"
+)
+
+func TestStateMachine_Validate_Valid(t *testing.T) {
+	sm, err := NewBuilder().
+		WithInitialState(&State{Name: "A"}).
+		AddState(&State{Name: "B"}).
+		FromGraph(`
+stateDiagram-v2
+    [*] --> A
+    A --> B : Next
+    B --> A : Back
+    B --> [*] : Stop
+`).
+		WithValidation().
+		Build()
+
+	if err != nil {
+		t.Errorf("A valid definition should have been built, got %v", err)
+	}
+
+	if err = sm.Validate(); err != nil {
+		t.Errorf("A valid definition should not have findings, got %v", err)
+	}
+}
+
+func TestStateMachine_Validate_Structure(t *testing.T) {
+	sm, _ := NewStateMachine(&State{Name: "A"}, context.Background())
+	sm.AddState(&State{Name: "B"})
+	sm.AddState(&State{Name: "C"})
+	sm.AddState(&State{Name: "D"})
+	sm.AddTransition("A", event.WithName("Next"), "B")
+	sm.AddTransition("C", event.WithName("Next"), "D")
+
+	err := sm.Validate()
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Validation should have failed with a ValidationError, got %v", err)
+	}
+
+	if len(validationErr.Findings) != 3 {
+		t.Errorf("There should be 3 findings, got %v", validationErr.Findings)
+	}
+
+	if !validationErr.Has(ErrUnreachableState) {
+		t.Error("C and D State, should have been reported as unreachable")
+	}
+
+	if !validationErr.Has(ErrDeadEndState) {
+		t.Error("B State, should have been reported as having no exit")
+	}
+}
+
+func TestStateMachine_Validate_CompositeExit(t *testing.T) {
+	sm, _ := NewStateMachine(&State{Name: "Parent"}, context.Background())
+	sm.AddSubState("Parent", &State{Name: "Child"})
+	sm.AddState(&State{Name: "Other"})
+	sm.AddTransition("Parent", event.WithName("Leave"), "Other")
+	sm.AddTransition("Other", event.WithName("Back"), "Parent")
+
+	if err := sm.Validate(); err != nil {
+		t.Errorf("Child State, should leave through its parent, got %v", err)
+	}
+}
+
+func TestStateMachineBuilder_WithValidation_AllFindings(t *testing.T) {
+	isValid := func(ctx context.Context, trigger Trigger) bool { return true }
+
+	_, err := NewBuilder().
+		WithInitialState(&State{Name: "A"}).
+		AddState(&State{Name: "B"}).
+		WithEventNames("Unused").
+		RegisterGuard("isValid", isValid).
+		FromGraph(`
+stateDiagram-v2
+    [*] --> A
+    A --> B : Next [isValid]
+    A --> A : Next [isValid]
+    A --> Missing : Jump
+    A --> B : Go
+    A --> A : Go
+`).
+		WithValidation().
+		Build()
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Build should have failed with a ValidationError, got %v", err)
+	}
+
+	if len(validationErr.Findings) != 5 {
+		t.Errorf("There should be 5 findings, got %v", validationErr.Findings)
+	}
+
+	if !validationErr.Has(ErrUnknownState) {
+		t.Error("Missing State, should have been reported")
+	}
+
+	if !validationErr.Has(ErrUnusedEvent) {
+		t.Error("Unused event, should have been reported")
+	}
+
+	if !validationErr.Has(ErrNondeterministicTransition) {
+		t.Error("Nondeterministic transitions, should have been reported")
+	}
+
+	if !validationErr.Has(ErrDeadEndState) {
+		t.Error("B State, should have been reported as having no exit")
+	}
+}
+
+func TestStateMachineBuilder_WithoutValidation_FailsFirst(t *testing.T) {
+	_, err := NewBuilder().
+		WithInitialState(&State{Name: "A"}).
+		FromGraph(`
+stateDiagram-v2
+    [*] --> A
+    A --> Missing : Jump
+`).
+		Build()
+
+	if !errors.Is(err, ErrUnknownState) {
+		t.Errorf("Build should have failed with ErrUnknownState, got %v", err)
+	}
+
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		t.Error("Without validation, Build should not return a ValidationError")
+	}
+}
+
+func TestStateMachineBuilder_WithValidation_LeavesHubUntouched(t *testing.T) {
+	hub := eventhub.NewEventHub(nil)
+
+	_, err := NewBuilder().
+		WithInitialState(&State{Name: "A"}).
+		AddState(&State{Name: "B"}).
+		FromGraph(`
+stateDiagram-v2
+    [*] --> A
+    A --> B : Next
+`).
+		SubscribeFrom(hub).
+		WithValidation().
+		Build()
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Build should have failed with a ValidationError, got %v", err)
+	}
+
+	if err = hub.PublishAndCollect(event.WithName("Next"), nil); err != nil {
+		t.Errorf("A state machine failing to build should not have subscribed to the hub, got %v", err)
+	}
+}